@@ -0,0 +1,93 @@
+package sugar
+
+import (
+	"os"
+	"sync"
+)
+
+// App owns listeners, connections, goroutines, timers, redis managers and stats of one server,
+// several apps can run in one process independently, package level functions use DefApp.
+// state of the process stays package level: ids like msgQueID and goID are unique across apps,
+// the clock (NowTick, Timestamp) is shared, switches like ReactorMode, UDPConnID and StopDrainTimeout are
+// read when listeners start or apps stop, and upgrade listeners, pid file and signal hooks belong to the process
+type App struct {
+	stop           int32 // stop sign
+	stopChan       chan os.Signal
+	stopLock       sync.Mutex
+	stopCheckMap   StopCheckMap
+	stopCheckIndex uint64
+
+	waitAll     *WaitGroup // wait all goroutines
+	goCount     int64      // number of goroutines
+	stopMap     map[uint64]chan struct{}
+	stopMapLock sync.Mutex
+
+	msgQueMap       map[uint32]IMsgQue
+	msgQueMapSync   sync.Mutex
+	udpMap          map[string]*udpMsgQue
+	udpAddrMap      map[string]*udpMsgQue // address to session, only used when UDPConnID enabled
+	udpMapLock      sync.Mutex
+	udpPendingCount int32
+	drainCount      int32
+	limiter         *connLimiter
+	pollers         []*poller
+	pollerIndex     uint32
+	pollerOnce      sync.Once
+	slowCheckOnce   sync.Once
+	wheel           *timerWheel
+	wheelOnce       sync.Once
+	cronJobs        map[string]*CronJob
+	cronLock        sync.Mutex
+
+	redisManagers   []*RedisManager
+	redisLock       sync.Mutex
+	waitAllForRedis sync.WaitGroup
+
+	stat Stat
+}
+
+func NewApp() *App {
+	app := &App{
+		stopCheckMap: StopCheckMap{M: map[uint64]string{}},
+		waitAll:      &WaitGroup{},
+		stopMap:      map[uint64]chan struct{}{},
+		msgQueMap:    map[uint32]IMsgQue{},
+		udpMap:       map[string]*udpMsgQue{},
+		udpAddrMap:   map[string]*udpMsgQue{},
+		cronJobs:     map[string]*CronJob{},
+	}
+	app.limiter = &connLimiter{ipCount: map[string]int{}, stat: &app.stat}
+	return app
+}
+
+func (r *App) addMsgQue(msgQue IMsgQue) {
+	r.msgQueMapSync.Lock()
+	r.msgQueMap[msgQue.ID()] = msgQue
+	r.msgQueMapSync.Unlock()
+}
+
+func (r *App) delMsgQue(id uint32) {
+	r.msgQueMapSync.Lock()
+	delete(r.msgQueMap, id)
+	r.msgQueMapSync.Unlock()
+}
+
+func (r *App) getMsgQue(id uint32) IMsgQue {
+	r.msgQueMapSync.Lock()
+	defer r.msgQueMapSync.Unlock()
+	return r.msgQueMap[id]
+}
+
+// MsgQues returns all connections and listeners of the app
+func (r *App) MsgQues() []IMsgQue {
+	r.msgQueMapSync.Lock()
+	defer r.msgQueMapSync.Unlock()
+	list := make([]IMsgQue, 0, len(r.msgQueMap))
+	for _, v := range r.msgQueMap {
+		list = append(list, v)
+	}
+	return list
+}
+
+// DefApp is used by package level functions like StartServer, Go and Stop
+var DefApp = NewApp()
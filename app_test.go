@@ -0,0 +1,72 @@
+package sugar
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func echo(t *testing.T, c net.Conn) {
+	msg := NewMsg(1, 1, 0, 0, []byte("hello"))
+	if _, err := c.Write(msg.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, MsgHeadSize+5)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[MsgHeadSize:]) != "hello" {
+		t.Fatalf("unexpected echo %q", buf[MsgHeadSize:])
+	}
+}
+
+func Test_AppIndependent(t *testing.T) {
+	a, b := NewApp(), NewApp()
+	addrA, addrB := freeAddr(t), freeAddr(t)
+	if err := a.StartServer("tcp://"+addrA, MsgTypeMsg, &EchoMsgHandler{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.StartServer("tcp://"+addrB, MsgTypeMsg, &EchoMsgHandler{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer a.Stop()
+
+	ca, err := net.Dial("tcp", addrA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ca.Close()
+	cb, err := net.Dial("tcp", addrB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, ca)
+	echo(t, cb)
+
+	cb.Close()
+	b.Stop()
+	if !b.IsStop() || a.IsStop() {
+		t.Fatal("stop should only affect one app")
+	}
+	if len(b.MsgQues()) != 0 {
+		t.Fatalf("stopped app has %d msgQues", len(b.MsgQues()))
+	}
+	if _, err := net.Dial("tcp", addrB); err == nil {
+		t.Fatal("listener of stopped app still accepting")
+	}
+	echo(t, ca)
+	if len(a.MsgQues()) != 2 || a.GetStat().MsgQueCount != 2 {
+		t.Fatalf("want listener and connection, got %d", len(a.MsgQues()))
+	}
+}
@@ -0,0 +1,158 @@
+package sugar
+
+import "time"
+
+// GameCalendar answers date questions of a game whose day starts at dayStartHour in loc,
+// e.g. with dayStartHour 5, 04:59 still belongs to the previous game day
+type GameCalendar struct {
+	loc          *time.Location
+	dayStartHour int
+	weekStart    time.Weekday
+}
+
+// NewGameCalendar creates a calendar whose weeks start on Monday, nil loc means time.Local
+func NewGameCalendar(loc *time.Location, dayStartHour int) *GameCalendar {
+	if loc == nil {
+		loc = time.Local
+	}
+	return &GameCalendar{loc: loc, dayStartHour: dayStartHour, weekStart: time.Monday}
+}
+
+// SetWeekStart changes the first day of game weeks
+func (r *GameCalendar) SetWeekStart(day time.Weekday) *GameCalendar {
+	r.weekStart = day
+	return r
+}
+
+func (r *GameCalendar) Location() *time.Location {
+	return r.loc
+}
+
+func (r *GameCalendar) boundary(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, r.dayStartHour, 0, 0, 0, r.loc)
+}
+
+// DayStart returns the start of the game day containing t
+func (r *GameCalendar) DayStart(t time.Time) time.Time {
+	t = t.In(r.loc)
+	y, m, d := t.Date()
+	start := r.boundary(y, m, d)
+	if t.Before(start) {
+		start = r.boundary(y, m, d-1)
+	}
+	return start
+}
+
+// WeekStart returns the start of the game week containing t
+func (r *GameCalendar) WeekStart(t time.Time) time.Time {
+	day := r.DayStart(t)
+	y, m, d := day.Date()
+	return r.boundary(y, m, d-(int(day.Weekday()-r.weekStart)+7)%7)
+}
+
+// MonthStart returns the start of the game month containing t
+func (r *GameCalendar) MonthStart(t time.Time) time.Time {
+	y, m, _ := r.DayStart(t).Date()
+	return r.boundary(y, m, 1)
+}
+
+func (r *GameCalendar) SameDay(a, b time.Time) bool {
+	return r.DayStart(a).Equal(r.DayStart(b))
+}
+
+func (r *GameCalendar) SameWeek(a, b time.Time) bool {
+	return r.WeekStart(a).Equal(r.WeekStart(b))
+}
+
+func (r *GameCalendar) SameMonth(a, b time.Time) bool {
+	return r.MonthStart(a).Equal(r.MonthStart(b))
+}
+
+// DayDiff returns the number of game day resets from old to now, negative if now is earlier
+func (r *GameCalendar) DayDiff(now, old time.Time) int {
+	days := func(t time.Time) int64 {
+		y, m, d := r.DayStart(t).Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400
+	}
+	return int(days(now) - days(old))
+}
+
+// NextDayReset returns the start of the game day after the one containing t
+func (r *GameCalendar) NextDayReset(t time.Time) time.Time {
+	y, m, d := r.DayStart(t).Date()
+	return r.boundary(y, m, d+1)
+}
+
+func (r *GameCalendar) NextWeekReset(t time.Time) time.Time {
+	y, m, d := r.WeekStart(t).Date()
+	return r.boundary(y, m, d+7)
+}
+
+func (r *GameCalendar) NextMonthReset(t time.Time) time.Time {
+	y, m, _ := r.MonthStart(t).Date()
+	return r.boundary(y, m+1, 1)
+}
+
+// ParseWindow parses start and end like "2006-01-02 15:04:05" in the calendar location
+func (r *GameCalendar) ParseWindow(start, end string) (TimeWindow, error) {
+	s, err := time.ParseInLocation(layout, start, r.loc)
+	if err != nil {
+		return TimeWindow{}, err
+	}
+	e, err := time.ParseInLocation(layout, end, r.loc)
+	if err != nil {
+		return TimeWindow{}, err
+	}
+	return TimeWindow{Start: s, End: e}, nil
+}
+
+// RecurringWindow creates a window opening by cron spec in the calendar location and lasting d
+func (r *GameCalendar) RecurringWindow(spec string, d time.Duration) (*RecurringWindow, error) {
+	schedule, err := ParseCron(spec, r.loc)
+	if err != nil {
+		return nil, err
+	}
+	return &RecurringWindow{Schedule: schedule, Duration: d}, nil
+}
+
+// TimeWindow is the time range [Start, End)
+type TimeWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (r TimeWindow) Contains(t time.Time) bool {
+	return !t.Before(r.Start) && t.Before(r.End)
+}
+
+// Left returns the time before the window ends, 0 if t is not in the window
+func (r TimeWindow) Left(t time.Time) time.Duration {
+	if !r.Contains(t) {
+		return 0
+	}
+	return r.End.Sub(t)
+}
+
+// RecurringWindow opens at each time of Schedule and stays open for Duration
+type RecurringWindow struct {
+	Schedule *CronSchedule
+	Duration time.Duration
+}
+
+// Active returns the window containing t
+func (r *RecurringWindow) Active(t time.Time) (TimeWindow, bool) {
+	start := r.Schedule.Next(t.Add(-r.Duration))
+	if start.IsZero() || start.After(t) {
+		return TimeWindow{}, false
+	}
+	return TimeWindow{Start: start, End: start.Add(r.Duration)}, true
+}
+
+// Next returns the first window opening after t
+func (r *RecurringWindow) Next(t time.Time) TimeWindow {
+	start := r.Schedule.Next(t)
+	if start.IsZero() {
+		return TimeWindow{}
+	}
+	return TimeWindow{Start: start, End: start.Add(r.Duration)}
+}
@@ -0,0 +1,55 @@
+package sugar
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_GameCalendar(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	cal := NewGameCalendar(ny, 5)
+	at := func(str string) time.Time {
+		v, _ := time.ParseInLocation(layout, str, ny)
+		return v
+	}
+	if !cal.SameDay(at("2024-03-10 04:59:59"), at("2024-03-09 05:00:00")) || cal.SameDay(at("2024-03-10 04:59:59"), at("2024-03-10 05:00:00")) {
+		t.Fatal("day boundary not at 05:00")
+	}
+	if d := cal.DayDiff(at("2024-03-12 06:00:00"), at("2024-03-10 04:00:00")); d != 3 {
+		t.Fatalf("day diff want 3 got %d", d)
+	}
+	// daylight saving starts on 2024-03-10, the game day is 23 hours long
+	if next := cal.NextDayReset(at("2024-03-10 01:00:00")); !next.Equal(at("2024-03-10 05:00:00")) {
+		t.Fatalf("next reset %v", next)
+	}
+	if ws := cal.WeekStart(at("2024-03-11 04:00:00")); !ws.Equal(at("2024-03-04 05:00:00")) {
+		t.Fatalf("week start %v", ws)
+	}
+	if !cal.SameWeek(at("2024-03-17 23:00:00"), at("2024-03-11 05:00:00")) || cal.SameMonth(at("2024-04-01 04:00:00"), at("2024-04-01 05:00:00")) {
+		t.Fatal("week or month boundary wrong")
+	}
+	if next := cal.NextMonthReset(at("2024-01-31 12:00:00")); !next.Equal(at("2024-02-01 05:00:00")) {
+		t.Fatalf("next month reset %v", next)
+	}
+
+	w, err := cal.ParseWindow("2024-05-01 10:00:00", "2024-05-02 10:00:00")
+	if err != nil || !w.Contains(at("2024-05-01 10:00:00")) || w.Contains(at("2024-05-02 10:00:00")) {
+		t.Fatal("window should be [start, end)")
+	}
+	rw, err := cal.RecurringWindow("@weekly Sat 20:00", 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cur, ok := rw.Active(at("2024-05-04 21:59:59")); !ok || !cur.Start.Equal(at("2024-05-04 20:00:00")) {
+		t.Fatalf("active window %v %v", cur, ok)
+	}
+	if _, ok := rw.Active(at("2024-05-04 22:00:00")); ok {
+		t.Fatal("window should be closed")
+	}
+	if next := rw.Next(at("2024-05-04 21:00:00")); !next.Start.Equal(at("2024-05-11 20:00:00")) {
+		t.Fatalf("next window %v", next)
+	}
+}
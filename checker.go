@@ -1,7 +1,6 @@
 package sugar
 
 import (
-	"os"
 	"sync"
 	"sync/atomic"
 )
@@ -12,31 +11,42 @@ type StopCheckMap struct {
 }
 
 func IsStop() bool {
-	return stop == 1
+	return DefApp.IsStop()
 }
 
 func IsRunning() bool {
-	return stop == 0
+	return DefApp.IsRunning()
 }
 
 func AddStopCheck(cs string) uint64 {
-	id := atomic.AddUint64(&stopCheckIndex, 1)
+	return DefApp.AddStopCheck(cs)
+}
+
+func RemoveStopCheck(id uint64) {
+	DefApp.RemoveStopCheck(id)
+}
+
+func (r *App) IsStop() bool {
+	return atomic.LoadInt32(&r.stop) == 1
+}
+
+func (r *App) IsRunning() bool {
+	return atomic.LoadInt32(&r.stop) == 0
+}
+
+func (r *App) AddStopCheck(cs string) uint64 {
+	id := atomic.AddUint64(&r.stopCheckIndex, 1)
 	if id == 0 {
-		id = atomic.AddUint64(&stopCheckIndex, 1)
+		id = atomic.AddUint64(&r.stopCheckIndex, 1)
 	}
-	stopCheckMap.Lock()
-	stopCheckMap.M[id] = cs
-	stopCheckMap.Unlock()
+	r.stopCheckMap.Lock()
+	r.stopCheckMap.M[id] = cs
+	r.stopCheckMap.Unlock()
 	return id
 }
 
-func RemoveStopCheck(id uint64) {
-	stopCheckMap.Lock()
-	delete(stopCheckMap.M, id)
-	stopCheckMap.Unlock()
+func (r *App) RemoveStopCheck(id uint64) {
+	r.stopCheckMap.Lock()
+	delete(r.stopCheckMap.M, id)
+	r.stopCheckMap.Unlock()
 }
-
-var stopCheckIndex uint64
-var stopCheckMap = StopCheckMap{M: map[uint64]string{}}
-var stop int32 // stop sign
-var stopChan chan os.Signal
@@ -0,0 +1,91 @@
+package sugar
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the time source of NowTick, Timestamp, timers and time helpers
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (r realClock) Now() time.Time {
+	return time.Now()
+}
+
+type clockHolder struct {
+	Clock
+}
+
+var clock atomic.Value
+
+func getClock() Clock {
+	if c, ok := clock.Load().(clockHolder); ok {
+		return c.Clock
+	}
+	return realClock{}
+}
+
+// SetClock replaces the clock of the process, nil restores the system clock.
+// timers keep their remaining delay if the clock moves backwards
+func SetClock(c Clock) {
+	if c == nil {
+		c = realClock{}
+	}
+	clock.Store(clockHolder{c})
+	refreshTick()
+}
+
+// ManualClock only moves when Advance or Set is invoked, used by tests
+type ManualClock struct {
+	now  time.Time
+	lock sync.Mutex
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (r *ManualClock) Now() time.Time {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.now
+}
+
+// Advance moves the clock forward by d, timers due are fired by the timing wheel shortly after
+func (r *ManualClock) Advance(d time.Duration) {
+	r.lock.Lock()
+	r.now = r.now.Add(d)
+	r.lock.Unlock()
+	refreshTick()
+}
+
+func (r *ManualClock) Set(now time.Time) {
+	r.lock.Lock()
+	r.now = now
+	r.lock.Unlock()
+	refreshTick()
+}
+
+// SkewedClock starts from a chosen time and runs rate times as fast as the system clock,
+// used to rehearse live events before they start
+type SkewedClock struct {
+	start time.Time
+	base  time.Time
+	rate  float64
+}
+
+func NewSkewedClock(start time.Time, rate float64) *SkewedClock {
+	if rate <= 0 {
+		rate = 1
+	}
+	return &SkewedClock{start: start, base: time.Now(), rate: rate}
+}
+
+func (r *SkewedClock) Now() time.Time {
+	return r.start.Add(time.Duration(float64(time.Since(r.base)) * r.rate))
+}
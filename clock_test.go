@@ -0,0 +1,46 @@
+package sugar
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_ManualClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	c := NewManualClock(start)
+	SetClock(c)
+	defer SetClock(nil)
+
+	app := NewApp()
+	defer app.Stop()
+	var fired int32
+	app.AfterFunc(int(2*time.Hour/time.Millisecond), func() { atomic.StoreInt32(&fired, 1) })
+	var daily int32
+	job := &CronJob{Name: "reset", Spec: "@daily 00:00", Location: time.UTC, Func: func(*CronJob, time.Time) { atomic.AddInt32(&daily, 1) }}
+	if err := app.AddCronJob(job); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Advance(time.Hour + time.Second)
+	if GetTimestamp() != start.Unix()+3601 || !Now().Equal(start.Add(time.Hour+time.Second)) {
+		t.Fatalf("tick not following clock, timestamp:%d", GetTimestamp())
+	}
+	waitFor := func(v *int32, want int32) bool {
+		for i := 0; i < 200 && atomic.LoadInt32(v) != want; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		return atomic.LoadInt32(v) == want
+	}
+	if !waitFor(&daily, 1) || atomic.LoadInt32(&fired) != 0 {
+		t.Fatalf("after 1 hour daily:%d fired:%d", daily, fired)
+	}
+	c.Advance(time.Hour)
+	if !waitFor(&fired, 1) {
+		t.Fatal("timer not fired after 2 hours")
+	}
+	c.Advance(24 * time.Hour)
+	if !waitFor(&daily, 2) {
+		t.Fatalf("daily job want 2 runs got %d", daily)
+	}
+}
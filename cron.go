@@ -0,0 +1,403 @@
+package sugar
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CronSchedule is a parsed cron expression evaluated in a time zone
+type CronSchedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	domAll bool
+	dowAll bool
+	loc    *time.Location
+}
+
+var cronMonthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+var cronDayNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+// ParseCron parses "minute hour day-of-month month day-of-week" or a descriptor:
+// @hourly, @daily, @weekly, @monthly, "@daily HH:MM", "@weekly Mon HH:MM", nil loc means time.Local
+func ParseCron(spec string, loc *time.Location) (*CronSchedule, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	fields := strings.Fields(spec)
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "@") {
+		var err error
+		if fields, err = cronDescriptor(fields); err != nil {
+			return nil, err
+		}
+	}
+	if len(fields) != 5 {
+		return nil, ErrCronSpec
+	}
+	r := &CronSchedule{loc: loc, domAll: fields[2] == "*", dowAll: fields[4] == "*"}
+	var err error
+	if r.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if r.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if r.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if r.month, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, err
+	}
+	if r.dow, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, err
+	}
+	if r.dow&(1<<7) != 0 {
+		r.dow |= 1 // 7 is sunday too
+	}
+	return r, nil
+}
+
+func cronDescriptor(fields []string) ([]string, error) {
+	at := func(hm string) (string, string, bool) {
+		v := strings.SplitN(hm, ":", 2)
+		if len(v) != 2 {
+			return "", "", false
+		}
+		h, err1 := strconv.Atoi(v[0])
+		m, err2 := strconv.Atoi(v[1])
+		return strconv.Itoa(m), strconv.Itoa(h), err1 == nil && err2 == nil
+	}
+	switch {
+	case fields[0] == "@hourly" && len(fields) == 1:
+		return []string{"0", "*", "*", "*", "*"}, nil
+	case fields[0] == "@daily" && len(fields) == 1:
+		return []string{"0", "0", "*", "*", "*"}, nil
+	case fields[0] == "@weekly" && len(fields) == 1:
+		return []string{"0", "0", "*", "*", "1"}, nil
+	case fields[0] == "@monthly" && len(fields) == 1:
+		return []string{"0", "0", "1", "*", "*"}, nil
+	case fields[0] == "@daily" && len(fields) == 2:
+		if m, h, ok := at(fields[1]); ok {
+			return []string{m, h, "*", "*", "*"}, nil
+		}
+	case fields[0] == "@weekly" && len(fields) == 3:
+		if m, h, ok := at(fields[2]); ok {
+			return []string{m, h, "*", "*", fields[1]}, nil
+		}
+	}
+	return nil, ErrCronSpec
+}
+
+func cronValue(str string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(str)]; ok {
+		return v, nil
+	}
+	if len(str) > 3 {
+		if v, ok := names[strings.ToLower(str[:3])]; ok {
+			return v, nil
+		}
+	}
+	v, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, ErrCronSpec
+	}
+	return v, nil
+}
+
+// parseCronField parses lists, ranges and steps like "1,5-10,*/15" into a bit set
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			v, err := strconv.Atoi(part[i+1:])
+			if err != nil || v <= 0 {
+				return 0, ErrCronSpec
+			}
+			step = v
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			rng := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = cronValue(rng[0], names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if len(rng) == 2 {
+				if hi, err = cronValue(rng[1], names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, ErrCronSpec
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (r *CronSchedule) matchDay(t time.Time) bool {
+	if r.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := r.dom&(1<<uint(t.Day())) != 0
+	dow := r.dow&(1<<uint(t.Weekday())) != 0
+	if r.domAll || r.dowAll {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first scheduled time after t, zero time if none in 5 years.
+// a time skipped by daylight saving runs right after the clock change, a repeated time runs once
+func (r *CronSchedule) Next(t time.Time) time.Time {
+	t = t.In(r.loc)
+	y, m, d := t.Date()
+	for i := 0; i < 366*5; i++ {
+		// noon always exists, midnight may be skipped by daylight saving
+		day := time.Date(y, m, d+i, 12, 0, 0, 0, r.loc)
+		if !r.matchDay(day) {
+			continue
+		}
+		dy, dm, dd := day.Date()
+		for h := 0; h < 24; h++ {
+			if r.hour&(1<<uint(h)) == 0 {
+				continue
+			}
+			for min := 0; min < 60; min++ {
+				if r.minute&(1<<uint(min)) == 0 {
+					continue
+				}
+				at := time.Date(dy, dm, dd, h, min, 0, 0, r.loc)
+				if at.Hour() != h || at.Minute() != min {
+					at = cronGap(at, h*60+min)
+				}
+				if at.After(t) {
+					return at
+				}
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// cronGap returns the first minute after the daylight saving gap which skips wall clock minute
+func cronGap(at time.Time, wall int) time.Time {
+	minute := func(t time.Time) int { return t.Hour()*60 + t.Minute() }
+	for i := 0; i < 240 && minute(at) < wall; i++ {
+		at = at.Add(time.Minute)
+	}
+	for i := 0; i < 240 && minute(at.Add(-time.Minute)) >= wall; i++ {
+		at = at.Add(-time.Minute)
+	}
+	return at
+}
+
+// Location returns the time zone the schedule is evaluated in
+func (r *CronSchedule) Location() *time.Location {
+	return r.loc
+}
+
+type CronCatchup int
+
+const (
+	CronCatchupSkip CronCatchup = iota // runs missed during downtime are skipped
+	CronCatchupOnce                    // run once at start if any run was missed
+	CronCatchupAll                     // run every missed time in order, at most CronMaxCatchup runs
+)
+
+// CronJob is a job added by AddCronJob, exported fields are set before adding
+type CronJob struct {
+	Name       string
+	Spec       string
+	Location   *time.Location // nil means time.Local
+	Catchup    CronCatchup
+	LastRun    time.Time                        // last run before downtime loaded by the caller, used by Catchup
+	Func       func(job *CronJob, at time.Time) // at is the scheduled time of the run
+	Dispatcher TimerDispatcher                  // nil means a new goroutine of the app
+
+	schedule *CronSchedule
+	timer    *Timer
+	next     time.Time
+	runCount int
+	running  int32
+	lock     sync.Mutex
+}
+
+// CronJobInfo is the state of a job shown by admin console
+type CronJobInfo struct {
+	Name     string
+	Spec     string
+	Location string
+	LastRun  time.Time
+	NextRun  time.Time
+	RunCount int
+	Running  bool
+}
+
+func (r *CronJob) Info() CronJobInfo {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return CronJobInfo{
+		Name:     r.Name,
+		Spec:     r.Spec,
+		Location: r.schedule.loc.String(),
+		LastRun:  r.LastRun,
+		NextRun:  r.next,
+		RunCount: r.runCount,
+		Running:  atomic.LoadInt32(&r.running) == 1,
+	}
+}
+
+// missed returns scheduled times in (LastRun, now]
+func (r *CronJob) missed(now time.Time) []time.Time {
+	if r.LastRun.IsZero() || r.Catchup == CronCatchupSkip {
+		return nil
+	}
+	var list []time.Time
+	for at := r.schedule.Next(r.LastRun); !at.IsZero() && !at.After(now); at = r.schedule.Next(at) {
+		list = append(list, at)
+		if r.Catchup == CronCatchupAll && len(list) > CronMaxCatchup {
+			list = list[1:]
+		}
+	}
+	if r.Catchup == CronCatchupOnce && len(list) > 1 {
+		list = list[len(list)-1:]
+	}
+	return list
+}
+
+// AddCronJob schedules job by its spec, job names are unique in an app
+func (r *App) AddCronJob(job *CronJob) error {
+	schedule, err := ParseCron(job.Spec, job.Location)
+	if err != nil {
+		return err
+	}
+	if job.Func == nil || job.Name == "" {
+		return ErrCronSpec
+	}
+	job.schedule = schedule
+	if job.Dispatcher == nil {
+		job.Dispatcher = r.Go
+	}
+	r.cronLock.Lock()
+	if _, ok := r.cronJobs[job.Name]; ok {
+		r.cronLock.Unlock()
+		return ErrCronJobExist
+	}
+	r.cronJobs[job.Name] = job
+	r.cronLock.Unlock()
+
+	job.timer = r.newTimer(0, func() { r.fireCron(job) }, TimerInline)
+	now := Now()
+	if missed := job.missed(now); len(missed) > 0 {
+		Infof("cron job:%s catch up %d runs since %v", job.Name, len(missed), job.LastRun)
+		r.runCron(job, missed)
+	}
+	r.scheduleCron(job, now)
+	return nil
+}
+
+// RemoveCronJob stops the job, a running invocation is not interrupted
+func (r *App) RemoveCronJob(name string) bool {
+	r.cronLock.Lock()
+	job, ok := r.cronJobs[name]
+	delete(r.cronJobs, name)
+	r.cronLock.Unlock()
+	if ok {
+		job.timer.Cancel()
+	}
+	return ok
+}
+
+// CronJobs lists jobs of the app ordered by name
+func (r *App) CronJobs() []CronJobInfo {
+	r.cronLock.Lock()
+	list := make([]CronJobInfo, 0, len(r.cronJobs))
+	for _, v := range r.cronJobs {
+		list = append(list, v.Info())
+	}
+	r.cronLock.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (r *App) scheduleCron(job *CronJob, now time.Time) {
+	next := job.schedule.Next(now)
+	job.lock.Lock()
+	job.next = next
+	job.lock.Unlock()
+	if next.IsZero() {
+		Warnf("cron job:%s has no next run", job.Name)
+		return
+	}
+	job.timer.Reset(cronDelay(next.Sub(now)))
+}
+
+// cronDelay keeps the delay of yearly jobs within int of 32-bit platforms, fireCron re-arms timers fired early
+func cronDelay(d time.Duration) int {
+	if d > cronMaxDelay {
+		d = cronMaxDelay
+	}
+	return int(d / time.Millisecond)
+}
+
+func (r *App) fireCron(job *CronJob) {
+	now := Now()
+	job.lock.Lock()
+	at := job.next
+	job.lock.Unlock()
+	if now.Before(at) {
+		// long delays are clamped and the wall clock may drift
+		job.timer.Reset(cronDelay(at.Sub(now)) + 1)
+		return
+	}
+	r.runCron(job, []time.Time{at})
+	r.scheduleCron(job, now)
+}
+
+func (r *App) runCron(job *CronJob, list []time.Time) {
+	if !atomic.CompareAndSwapInt32(&job.running, 0, 1) {
+		Warnf("cron job:%s still running, skip run at %v", job.Name, list[len(list)-1])
+		return
+	}
+	job.Dispatcher(func() {
+		defer atomic.StoreInt32(&job.running, 0)
+		for _, at := range list {
+			job.Func(job, at)
+			job.lock.Lock()
+			job.LastRun = at
+			job.runCount++
+			job.lock.Unlock()
+		}
+	})
+}
+
+func AddCronJob(job *CronJob) error {
+	return DefApp.AddCronJob(job)
+}
+
+func RemoveCronJob(name string) bool {
+	return DefApp.RemoveCronJob(name)
+}
+
+func CronJobs() []CronJobInfo {
+	return DefApp.CronJobs()
+}
+
+var CronMaxCatchup = 100 // max missed runs executed by CronCatchupAll
+
+const cronMaxDelay = 24 * time.Hour // far below the 24.8 days int32 of ms holds
@@ -0,0 +1,71 @@
+package sugar
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_CronNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	cases := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"*/15 * * * *", "2024-01-01 10:07", "2024-01-01 10:15"},
+		{"@daily 05:00", "2024-01-01 05:00", "2024-01-02 05:00"},
+		{"@weekly Mon 05:00", "2024-01-03 00:00", "2024-01-08 05:00"},
+		{"0 0 1 jan-mar *", "2024-03-02 00:00", "2025-01-01 00:00"},
+		{"30 2 * * *", "2024-03-10 00:00", "2024-03-10 03:00"}, // skipped by daylight saving
+		{"30 1 * * *", "2024-11-03 00:00", "2024-11-03 01:30"}, // repeated by daylight saving
+	}
+	for _, c := range cases {
+		s, err := ParseCron(c.spec, ny)
+		if err != nil {
+			t.Fatalf("parse %s err:%v", c.spec, err)
+		}
+		from, _ := time.ParseInLocation("2006-01-02 15:04", c.from, ny)
+		if got := s.Next(from).Format("2006-01-02 15:04"); got != c.want {
+			t.Fatalf("%s from %s want %s got %s", c.spec, c.from, c.want, got)
+		}
+	}
+	s, _ := ParseCron("30 1 * * *", ny)
+	from, _ := time.ParseInLocation("2006-01-02 15:04", "2024-11-03 00:00", ny)
+	if next := s.Next(s.Next(from)); next.Day() != 4 {
+		t.Fatalf("repeated time should run once, next %v", next)
+	}
+	for _, spec := range []string{"* * *", "60 * * * *", "@daily 5", "* * * * */0"} {
+		if _, err := ParseCron(spec, nil); err != ErrCronSpec {
+			t.Fatalf("%s should be invalid", spec)
+		}
+	}
+}
+
+func Test_CronCatchup(t *testing.T) {
+	job := &CronJob{Spec: "@hourly", Location: time.UTC, LastRun: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	job.schedule, _ = ParseCron(job.Spec, job.Location)
+	now := time.Date(2024, 1, 1, 5, 30, 0, 0, time.UTC)
+	if n := len(job.missed(now)); n != 0 {
+		t.Fatalf("skip want 0 got %d", n)
+	}
+	job.Catchup = CronCatchupOnce
+	if list := job.missed(now); len(list) != 1 || list[0].Hour() != 5 {
+		t.Fatalf("once want 05:00 got %v", list)
+	}
+	job.Catchup = CronCatchupAll
+	if n := len(job.missed(now)); n != 5 {
+		t.Fatalf("all want 5 got %d", n)
+	}
+}
+
+func Test_CronDelay(t *testing.T) {
+	if d := cronDelay(time.Minute); d != 60000 {
+		t.Fatalf("want 60000 got %d", d)
+	}
+	if d := cronDelay(365 * 24 * time.Hour); d != int(cronMaxDelay/time.Millisecond) || int64(d) > 1<<31-1 {
+		t.Fatalf("long delay not clamped %d", d)
+	}
+}
@@ -0,0 +1,256 @@
+package sugar
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	daemonEnv      = "SUGAR_DAEMON"
+	daemonEnvPidFd = "SUGAR_DAEMON_PIDFD" // fd of the pid file locked by the parent process
+)
+
+type DaemonConfig struct {
+	PidFile string   // pid is written to it and locked while running, empty disables
+	Stdout  string   // file stdout of daemon process is appended to, empty discards output
+	Stderr  string   // file stderr of daemon process is appended to, empty means same as Stdout
+	WorkDir string   // working directory of daemon process, empty keeps current
+	Skip    []string // arguments contain any of them are removed when daemon process is started
+}
+
+// Daemon starts the program in background, see StartDaemon
+func Daemon(skip ...string) error {
+	return StartDaemon(&DaemonConfig{Skip: skip})
+}
+
+// StartDaemon re-executes the program detached from terminal, it should be invoked before StartServer.
+// ErrDaemonStarted is returned in the current process after the daemon process started, main should return then.
+// in the daemon process it locks the pid file, handles StatSignal and LogReopenSignal and returns nil
+func StartDaemon(cfg *DaemonConfig) error {
+	if os.Getenv(daemonEnv) == "" {
+		// locked before spawning and inherited by the daemon process, so concurrent starts can't both pass
+		pid, err := openPidFile(cfg.PidFile)
+		if err != nil {
+			return err
+		}
+		err = spawnDaemon(cfg, pid)
+		if pid != nil {
+			pid.Close()
+		}
+		if err != nil {
+			return err
+		}
+		return ErrDaemonStarted
+	}
+	daemonConfig = cfg
+	handleDaemonSignals()
+	// a process started by Upgrade waits the old one to release the pid file
+	return lockPidFile(cfg.PidFile, os.Getenv(upgradeEnvListeners) != "")
+}
+
+func daemonCommand(cfg *DaemonConfig, pid *os.File) (*exec.Cmd, error) {
+	filePath, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	var newCmd []string
+	for _, v := range os.Args {
+		add := true
+		for _, s := range cfg.Skip {
+			if strings.Contains(v, s) {
+				add = false
+				break
+			}
+		}
+		if add {
+			newCmd = append(newCmd, v)
+		}
+	}
+	cmd := exec.Command(filePath)
+	cmd.Args = newCmd
+	cmd.Dir = cfg.WorkDir
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	if pid != nil {
+		cmd.ExtraFiles = []*os.File{pid}
+		cmd.Env = append(cmd.Env, daemonEnvPidFd+"=3")
+	}
+	if cfg.Stdout != "" {
+		if cmd.Stdout, err = openLogFile(cfg.Stdout); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Stderr != "" {
+		if cmd.Stderr, err = openLogFile(cfg.Stderr); err != nil {
+			return nil, err
+		}
+	} else {
+		cmd.Stderr = cmd.Stdout
+	}
+	return cmd, nil
+}
+
+func openLogFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+}
+
+func lockPidFile(path string, wait bool) error {
+	if path == "" {
+		return nil
+	}
+	var f *os.File
+	if fd, err := strconv.Atoi(os.Getenv(daemonEnvPidFd)); err == nil {
+		// not passed on to processes started by Upgrade
+		os.Unsetenv(daemonEnvPidFd)
+		f = os.NewFile(uintptr(fd), path)
+	} else if f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644); err != nil {
+		return err
+	}
+	write := func() {
+		f.Truncate(0)
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+		f.Sync()
+		pidLock.Lock()
+		pidFile = f
+		pidLock.Unlock()
+	}
+	if tryLockFile(f) {
+		write()
+		return nil
+	}
+	if !wait {
+		f.Close()
+		return ErrDaemonRunning
+	}
+	ok := Go2(func(stopCh chan struct{}) {
+		for !tryLockFile(f) {
+			select {
+			case <-stopCh:
+				f.Close()
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		write()
+	})
+	if !ok {
+		f.Close()
+	}
+	return nil
+}
+
+// openPidFile returns the locked pid file, nil if path is empty
+func openPidFile(path string) (*os.File, error) {
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if !tryLockFile(f) {
+		f.Close()
+		return nil, ErrDaemonRunning
+	}
+	return f, nil
+}
+
+// releasePidFile removes pid file unless it will be locked by the upgraded process
+func releasePidFile() {
+	pidLock.Lock()
+	defer pidLock.Unlock()
+	if pidFile == nil {
+		return
+	}
+	if !upgraded {
+		os.Remove(pidFile.Name())
+	}
+	pidFile.Close()
+	pidFile = nil
+}
+
+// ReopenLog reopens stdout and stderr files of daemon process, used after log rotation
+func ReopenLog() error {
+	if daemonConfig == nil || daemonConfig.Stdout == "" {
+		return nil
+	}
+	stderr := daemonConfig.Stderr
+	if stderr == "" {
+		stderr = daemonConfig.Stdout
+	}
+	for fd, path := range map[int]string{1: daemonConfig.Stdout, 2: stderr} {
+		f, err := openLogFile(path)
+		if err != nil {
+			return err
+		}
+		err = dupFile(f, fd)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleSignal registers fn invoked by WaitForSystemExit when sig received, sig no longer stops the server
+func HandleSignal(sig os.Signal, fn func()) {
+	if sig == nil || fn == nil {
+		return
+	}
+	signalHookLock.Lock()
+	signalHooks[sig] = append(signalHooks[sig], fn)
+	signalHookLock.Unlock()
+	DefApp.stopLock.Lock()
+	if DefApp.stopChan != nil {
+		signal.Notify(DefApp.stopChan, sig)
+	}
+	DefApp.stopLock.Unlock()
+}
+
+// OnReload registers fn invoked when ReloadSignal received
+func OnReload(fn func()) {
+	HandleSignal(ReloadSignal, fn)
+}
+
+func notifyHookSignals(c chan os.Signal) {
+	signalHookLock.Lock()
+	defer signalHookLock.Unlock()
+	for sig := range signalHooks {
+		signal.Notify(c, sig)
+	}
+}
+
+func runSignalHooks(sig os.Signal) bool {
+	if sig == nil {
+		return false
+	}
+	signalHookLock.Lock()
+	hooks := signalHooks[sig]
+	signalHookLock.Unlock()
+	if len(hooks) == 0 {
+		return false
+	}
+	Infof("signal %v received", sig)
+	for _, fn := range hooks {
+		Try(fn, nil)
+	}
+	return true
+}
+
+func dumpStat() {
+	s := GetStat()
+	Infof("stat start:%v goroutines:%d msgQues:%d panics:%d slow consumers:%d rejected conns:%d", s.StartTime, s.GoCount, s.MsgQueCount,
+		s.PanicCount, s.SlowConsumerCount, s.RejectConnCount+s.RejectIPCount+s.RejectRateCount+s.RejectFilterCount+s.RejectPendingCount)
+}
+
+var daemonConfig *DaemonConfig
+var pidFile *os.File
+var pidLock sync.Mutex
+var upgraded bool
+var signalHooks = map[os.Signal][]func(){}
+var signalHookLock sync.Mutex
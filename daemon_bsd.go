@@ -0,0 +1,13 @@
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly
+// +build darwin freebsd netbsd openbsd dragonfly
+
+package sugar
+
+import (
+	"os"
+	"syscall"
+)
+
+func dupFile(f *os.File, fd int) error {
+	return syscall.Dup2(int(f.Fd()), fd)
+}
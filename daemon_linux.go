@@ -0,0 +1,13 @@
+//go:build linux
+// +build linux
+
+package sugar
+
+import (
+	"os"
+	"syscall"
+)
+
+func dupFile(f *os.File, fd int) error {
+	return syscall.Dup3(int(f.Fd()), fd, 0)
+}
@@ -0,0 +1,25 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package sugar
+
+import "os"
+
+// signals are not supported, OnReload and HandleSignal with these signals do nothing
+var ReloadSignal os.Signal
+var StatSignal os.Signal
+var LogReopenSignal os.Signal
+
+func handleDaemonSignals() {}
+
+func spawnDaemon(cfg *DaemonConfig, pid *os.File) error {
+	return ErrDaemonUnsupported
+}
+
+func tryLockFile(f *os.File) bool {
+	return true
+}
+
+func dupFile(f *os.File, fd int) error {
+	return ErrDaemonUnsupported
+}
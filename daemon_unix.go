@@ -0,0 +1,44 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package sugar
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+var ReloadSignal os.Signal = syscall.SIGHUP     // hooks registered by OnReload
+var StatSignal os.Signal = syscall.SIGUSR1      // dumps stat to log
+var LogReopenSignal os.Signal = syscall.SIGUSR2 // reopens daemon output files
+
+var daemonSignalOnce sync.Once
+
+// handleDaemonSignals is only invoked in daemon process, other programs importing the package keep their handling
+func handleDaemonSignals() {
+	daemonSignalOnce.Do(func() {
+		HandleSignal(StatSignal, dumpStat)
+		HandleSignal(LogReopenSignal, func() {
+			if err := ReopenLog(); err != nil {
+				Errorf("reopen log failed, err:%v", err)
+			}
+		})
+	})
+}
+
+func spawnDaemon(cfg *DaemonConfig, pid *os.File) error {
+	cmd, err := daemonCommand(cfg, pid)
+	if err != nil {
+		return err
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+func tryLockFile(f *os.File) bool {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) == nil
+}
@@ -0,0 +1,137 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+// +build linux darwin freebsd netbsd openbsd dragonfly
+
+package sugar
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const daemonTestEnv = "SUGAR_TEST_DAEMON_PID"
+
+func readPid(path string) int {
+	data, _ := ioutil.ReadFile(path)
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// Test_DaemonChild is the daemon process started by Test_Daemon
+func Test_DaemonChild(t *testing.T) {
+	path := os.Getenv(daemonTestEnv)
+	if path == "" {
+		t.Skip("only run by Test_Daemon")
+	}
+	if err := StartDaemon(&DaemonConfig{PidFile: path}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openPidFile(path); err != ErrDaemonRunning {
+		t.Fatalf("pid file should be locked, err:%v", err)
+	}
+	if len(signalHooks[LogReopenSignal]) == 0 {
+		t.Fatal("daemon signals not handled")
+	}
+	if readPid(path) == os.Getpid() {
+		fmt.Println("daemon ok")
+	}
+	releasePidFile()
+}
+
+func Test_Daemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidPath, outPath := filepath.Join(dir, "pid"), filepath.Join(dir, "out")
+
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^Test_DaemonChild$"}
+	os.Setenv(daemonTestEnv, pidPath)
+	defer func() {
+		os.Args = args
+		os.Unsetenv(daemonTestEnv)
+	}()
+	if err := StartDaemon(&DaemonConfig{PidFile: pidPath, Stdout: outPath}); err != ErrDaemonStarted {
+		t.Fatalf("want ErrDaemonStarted got %v", err)
+	}
+	if len(signalHooks[LogReopenSignal]) != 0 {
+		t.Fatal("signals handled outside daemon process")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, _ := ioutil.ReadFile(outPath); strings.Contains(string(data), "daemon ok") {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("daemon output %q", data)
+		}
+		Sleep(10)
+	}
+}
+
+func Test_PidFileLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pid")
+
+	holder, err := openPidFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openPidFile(path); err != ErrDaemonRunning {
+		t.Fatalf("want ErrDaemonRunning got %v", err)
+	}
+	if err := lockPidFile(path, false); err != ErrDaemonRunning {
+		t.Fatalf("want ErrDaemonRunning got %v", err)
+	}
+
+	// the lock taken by the parent is inherited
+	fd, err := syscall.Dup(int(holder.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(daemonEnvPidFd, strconv.Itoa(fd))
+	if err := lockPidFile(path, false); err != nil || readPid(path) != os.Getpid() {
+		t.Fatalf("inherited lock err:%v", err)
+	}
+	if os.Getenv(daemonEnvPidFd) != "" {
+		t.Fatal("pid fd should not be passed on")
+	}
+	holder.Close()
+	releasePidFile()
+
+	// an upgraded process waits the old one
+	holder, _ = openPidFile(path)
+	if err := lockPidFile(path, true); err != nil {
+		t.Fatal(err)
+	}
+	Sleep(200)
+	pidLock.Lock()
+	locked := pidFile != nil
+	pidLock.Unlock()
+	if locked {
+		t.Fatal("pid file locked while held by others")
+	}
+	holder.Close()
+	for deadline := time.Now().Add(2 * time.Second); ; Sleep(10) {
+		pidLock.Lock()
+		locked = pidFile != nil
+		pidLock.Unlock()
+		if locked {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("pid file not locked after released")
+		}
+	}
+	releasePidFile()
+}
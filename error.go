@@ -30,6 +30,27 @@ var (
 	ErrMsgLenTooShort = NewError("message too short", 7)
 	ErrDBDataType     = NewError("bad db type", 8)
 
+	ErrMsgQueKicked      = NewError("msgQue kicked by new login", 9)
+	ErrMsgQueBindReject  = NewError("msgQue bind rejected", 10)
+	ErrSessionNotFound   = NewError("session not found", 11)
+	ErrSessionLost       = NewError("session lost unacknowledged messages", 12)
+	ErrUDPSendDropped    = NewError("udp datagram dropped", 13)
+	ErrMuxListenerClosed = NewError("mux listener closed", 14)
+	ErrWSHandshake       = NewError("websocket handshake failed", 15)
+	ErrUpgradeRunning    = NewError("upgrade is running", 16)
+	ErrUpgradeTimeout    = NewError("upgrade process not ready in time", 17)
+	ErrUpgradeFailed     = NewError("upgrade process failed", 18)
+	ErrDaemonRunning     = NewError("daemon is running already", 19)
+	ErrDaemonUnsupported = NewError("daemon is not supported on this platform", 20)
+	ErrCronSpec          = NewError("bad cron job spec", 21)
+	ErrCronJobExist      = NewError("cron job exists already", 22)
+	ErrLockNotAcquired   = NewError("lock is held by others", 23)
+	ErrLockLost          = NewError("lock lease lost", 24)
+	ErrShardNodeNotFound = NewError("redis of shard node not found", 25)
+	ErrScriptNotFound    = NewError("redis script not found", 26)
+	ErrReactorConn       = NewError("connection is driven by reactor", 27)
+	ErrDaemonStarted     = NewError("daemon process started", 28)
+
 	ErrErrIDNotFound = NewError("unknown error code", 255)
 )
 
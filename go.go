@@ -1,15 +1,15 @@
 package sugar
 
 import (
-	"sync"
 	"sync/atomic"
 )
 
-var stopMap = map[uint64]chan struct{}{}
-var stopMapLock sync.Mutex
-
 // Try
 func Try(fun func(), handler func(interface{})) {
+	DefApp.Try(fun, handler)
+}
+
+func (r *App) Try(fun func(), handler func(interface{})) {
 	defer func() {
 		if err := recover(); err != nil {
 			if handler == nil {
@@ -18,8 +18,8 @@ func Try(fun func(), handler func(interface{})) {
 			} else {
 				handler(err)
 			}
-			atomic.AddInt32(&stat.PanicCount, 1)
-			stat.LastPanic = int(Timestamp)
+			atomic.AddInt32(&r.stat.PanicCount, 1)
+			r.stat.LastPanic = int(GetTimestamp())
 		}
 	}()
 	fun()
@@ -27,56 +27,65 @@ func Try(fun func(), handler func(interface{})) {
 
 // Go
 func Go(fn func()) {
-	waitAll.Add(1)
+	DefApp.Go(fn)
+}
+
+func (r *App) Go(fn func()) {
+	r.waitAll.Add(1)
 	id := atomic.AddUint64(&goID, 1)
-	c := atomic.AddInt64(&goCount, 1)
+	c := atomic.AddInt64(&r.goCount, 1)
 	DebugRoutineStartStack(id, c)
 	go func() {
-		Try(fn, nil)
-		waitAll.Done()
-		c = atomic.AddInt64(&goCount, -1)
+		r.Try(fn, nil)
+		r.waitAll.Done()
+		c = atomic.AddInt64(&r.goCount, -1)
 
 		DebugRoutineEndStack(id, c)
 	}()
 }
 
 // notifyRoutinesClose
-func notifyRoutinesClose() {
-	stopMapLock.Lock()
-	for k, v := range stopMap {
+func (r *App) notifyRoutinesClose() {
+	r.stopMapLock.Lock()
+	for k, v := range r.stopMap {
 		close(v)
-		delete(stopMap, k)
+		delete(r.stopMap, k)
 	}
-	stopMapLock.Unlock()
+	r.stopMapLock.Unlock()
 }
 
 // Go2
 func Go2(fn func(stopCh chan struct{})) bool {
-	if IsStop() {
+	return DefApp.Go2(fn)
+}
+
+func (r *App) Go2(fn func(stopCh chan struct{})) bool {
+	// registered before the goroutine starts so a Stop right after always closes stopCh
+	stopCh := make(chan struct{})
+	id := atomic.AddUint64(&goID, 1)
+	r.stopMapLock.Lock()
+	if r.IsStop() {
+		r.stopMapLock.Unlock()
 		return false
 	}
-	waitAll.Add(1)
-	id := atomic.AddUint64(&goID, 1)
-	c := atomic.AddInt64(&goCount, 1)
+	r.stopMap[id] = stopCh
+	r.waitAll.Add(1)
+	r.stopMapLock.Unlock()
+	c := atomic.AddInt64(&r.goCount, 1)
 	DebugRoutineStartStack(id, c)
 
 	go func() {
-		id := atomic.AddUint64(&goID, 1)
-		stopCh := make(chan struct{})
-		stopMapLock.Lock()
-		stopMap[id] = stopCh
-		stopMapLock.Unlock()
-		Try(func() { fn(stopCh) }, nil)
-
-		stopMapLock.Lock()
-		if _, ok := stopMap[id]; ok {
+		r.Try(func() { fn(stopCh) }, nil)
+
+		r.stopMapLock.Lock()
+		if _, ok := r.stopMap[id]; ok {
 			close(stopCh)
-			delete(stopMap, id)
+			delete(r.stopMap, id)
 		}
-		stopMapLock.Unlock()
+		r.stopMapLock.Unlock()
 
-		waitAll.Done()
-		c = atomic.AddInt64(&goCount, -1)
+		r.waitAll.Done()
+		c = atomic.AddInt64(&r.goCount, -1)
 		DebugRoutineEndStack(id, c)
 	}()
 	return true
@@ -84,16 +93,20 @@ func Go2(fn func(stopCh chan struct{})) bool {
 
 // GoArgs
 func GoArgs(fn func(...interface{}), args ...interface{}) {
-	waitAll.Add(1)
+	DefApp.GoArgs(fn, args...)
+}
+
+func (r *App) GoArgs(fn func(...interface{}), args ...interface{}) {
+	r.waitAll.Add(1)
 	id := atomic.AddUint64(&goID, 1)
-	c := atomic.AddInt64(&goCount, 1)
+	c := atomic.AddInt64(&r.goCount, 1)
 	DebugRoutineStartStack(id, c)
 
 	go func() {
-		Try(func() { fn(args...) }, nil)
+		r.Try(func() { fn(args...) }, nil)
 
-		waitAll.Done()
-		c = atomic.AddInt64(&goCount, -1)
+		r.waitAll.Done()
+		c = atomic.AddInt64(&r.goCount, -1)
 		DebugRoutineEndStack(id, c)
 	}()
 }
@@ -1,8 +1,11 @@
 package sugar
 
 import (
+	"encoding/binary"
 	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type MsgType int
@@ -37,7 +40,9 @@ type IMsgQue interface {
 	RemoteAddr() string
 
 	Stop()
+	Drain(timeout time.Duration) //stop accepting sends, flush pending messages within timeout, then stop
 	IsStop() bool
+	IsDraining() bool
 	Available() bool
 
 	Send(m *Message) (re bool)
@@ -46,8 +51,13 @@ type IMsgQue interface {
 	SendByteStr(str []byte) (re bool)
 	SendByteStrLn(str []byte) (re bool)
 	SendCallback(m *Message, c chan *Message) (re bool)
-	SetTimeout(t int)
+	SetTimeout(t int) //set both read and write timeout, unit: s
 	GetTimeout() int
+	SetReadTimeout(t time.Duration)
+	GetReadTimeout() time.Duration
+	SetWriteTimeout(t time.Duration)
+	GetWriteTimeout() time.Duration
+	GetWriteStat() MsgQueWriteStat
 	Reconnect(t int) //reconnect interval, unit: s, this function only can be invoked when connection was closed
 
 	GetHandler() IMsgHandler
@@ -58,20 +68,29 @@ type IMsgQue interface {
 	GetExtData() interface{}
 
 	tryCallback(msg *Message) (re bool)
+	checkSlow()
 }
 
 type msgQue struct {
-	id uint32 //uniquely identify
-
-	writeCh chan *Message //write channel
-	stop    int32         //stop token
-	msgTyp  MsgType       //message type
+	id  uint32 //uniquely identify
+	app *App   //owner of the msgQue
+
+	writeCh chan *Message //write channel, also the normal priority lane
+	highCh  chan *Message //high priority lane
+	lowCh   chan *Message //low priority lane
+	picker  priorityPicker
+	wstat   writeStat
+	stop    int32   //stop token
+	drain   int32   //draining token
+	msgTyp  MsgType //message type
 	connTyp ConnType
 
 	handler       IMsgHandler
 	parser        IParser
 	parserFactory *Parser
-	timeout       int
+	opts          *ConnOptions
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
 
 	init         bool
 	available    bool
@@ -79,12 +98,45 @@ type msgQue struct {
 	user         interface{}
 	extData      interface{}
 	callbackLock sync.Mutex
+	binder       *MsgQueBinder
+	bindLock     sync.Mutex
+	bindClosed   bool
+	session      atomic.Value //*MsgSession, set by the goroutine resuming it
+	limited      bool         //counted by connection limiter
+	limitKey     string       //ip counted by connection limiter
+	reactor      bool         //io driven by reactor instead of read and write goroutines
+	sender       func(m *Message) bool
+	readExit     int32 //read side finished, used by drain
+	writeExit    int32 //write side finished, used by drain
 }
 
 func (r *msgQue) SetUser(user interface{}) {
 	r.user = user
 }
 
+// setBinder runs bind and records binder unless the msgQue is stopped
+func (r *msgQue) setBinder(binder *MsgQueBinder, bind func() bool) bool {
+	r.bindLock.Lock()
+	defer r.bindLock.Unlock()
+	if r.bindClosed || atomic.LoadInt32(&r.stop) == 1 || !bind() {
+		return false
+	}
+	if r.binder != nil && r.binder != binder {
+		r.binder.unbindID(r.id)
+	}
+	r.binder = binder
+	return true
+}
+
+func (r *msgQue) setSession(session *MsgSession) {
+	r.session.Store(session)
+}
+
+func (r *msgQue) getSession() *MsgSession {
+	session, _ := r.session.Load().(*MsgSession)
+	return session
+}
+
 func (r *msgQue) Available() bool {
 	return r.available
 }
@@ -118,27 +170,62 @@ func (r *msgQue) ID() uint32 {
 }
 
 func (r *msgQue) SetTimeout(t int) {
-	r.timeout = t
+	r.readTimeout = time.Duration(t) * time.Second
+	r.writeTimeout = r.readTimeout
 }
 
 func (r *msgQue) GetTimeout() int {
-	return r.timeout
+	return int(r.readTimeout / time.Second)
+}
+
+func (r *msgQue) SetReadTimeout(t time.Duration) {
+	r.readTimeout = t
+}
+
+func (r *msgQue) GetReadTimeout() time.Duration {
+	return r.readTimeout
+}
+
+func (r *msgQue) SetWriteTimeout(t time.Duration) {
+	r.writeTimeout = t
+}
+
+func (r *msgQue) GetWriteTimeout() time.Duration {
+	return r.writeTimeout
 }
 func (r *msgQue) Reconnect(t int) {
 
 }
 
 func (r *msgQue) Send(m *Message) (re bool) {
-	if m == nil || !r.available {
+	if m == nil || !r.available || r.IsDraining() {
 		return
 	}
+	if r.sender != nil {
+		return r.sender(m)
+	}
+	size := r.msgSize(m)
+	bytes := r.queued(size)
 	defer func() {
 		if err := recover(); err != nil {
+			r.unqueue(size)
 			re = false
 		}
 	}()
 
-	r.writeCh <- m
+	ch := r.lane(m.priority)
+	if r.opts.slowDetect() && r.opts.SlowDrop {
+		select {
+		case ch <- m:
+		default:
+			r.unqueue(size)
+			r.reportSlow()
+			return false
+		}
+	} else {
+		ch <- m
+	}
+	r.checkQueued(bytes)
 	return true
 }
 
@@ -217,18 +304,41 @@ func (r *msgQue) BaseStop() {
 	if r.writeCh != nil {
 		close(r.writeCh)
 	}
+	if r.highCh != nil {
+		close(r.highCh)
+	}
+	if r.lowCh != nil {
+		close(r.lowCh)
+	}
 
 	for k, v := range r.callback {
 		v <- nil
 		delete(r.callback, k)
 	}
-	msgQueMapSync.Lock()
-	delete(msgQueMap, r.id)
-	msgQueMapSync.Unlock()
+	r.bindLock.Lock()
+	r.bindClosed = true
+	binder := r.binder
+	r.bindLock.Unlock()
+	if binder != nil {
+		binder.unbindID(r.id)
+	}
+	if session := r.getSession(); session != nil {
+		session.detach(r.id, r.app)
+	}
+	if r.limited {
+		r.app.limiter.releaseRef(&r.limitKey)
+	}
+	r.app.delMsgQue(r.id)
 	Infof("msgQue close id:%d", r.id)
 }
 
 func (r *msgQue) processMsg(msgQue IMsgQue, msg *Message) bool {
+	if session := r.getSession(); session != nil && msg.Head != nil && msg.Head.Flags&FlagAck != 0 {
+		if len(msg.Data) >= SessionSeqSize {
+			session.Ack(binary.LittleEndian.Uint32(msg.Data))
+		}
+		return true
+	}
 	if r.parser != nil && msg.Data != nil {
 		mp, err := r.parser.ParseC2S(msg)
 		if err == nil {
@@ -328,6 +438,16 @@ func (r *EchoMsgHandler) OnProcessMsg(msgQue IMsgQue, msg *Message) bool {
 }
 
 var msgQueID uint32 //message queue ID
-var msgQueMapSync sync.Mutex
-var msgQueMap = map[uint32]IMsgQue{}
 var DefMsgQueTimeout = 180
+
+// ReactorMode makes listeners started afterwards serve accepted connections without per connection goroutines,
+// tcp connections are driven by epoll pollers on linux, udp sessions are processed in the listen goroutines
+var ReactorMode = false
+var ReactorPollerCnt = 0                 // number of epoll pollers, 0 means runtime.NumCPU()
+var ReactorMaxWriteBuf = 4 * 1024 * 1024 // max pending write bytes per reactor connection
+var ReactorMaxPendingMsgs = 1024         // reading of a reactor connection pauses when so many messages wait for the handler
+
+// ReactorInlineHandler runs handlers on the poller goroutines instead of a goroutine started per busy connection.
+// WARNING: it saves a goroutine switch per message, but a handler that blocks or runs long stalls every
+// connection of the poller, only enable it when all handlers return in microseconds
+var ReactorInlineHandler = false
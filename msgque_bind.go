@@ -0,0 +1,190 @@
+package sugar
+
+import (
+	"sync"
+	"time"
+)
+
+type BindPolicy int
+
+const (
+	BindPolicyKickOld   BindPolicy = iota // kick the old msgQue with an error message, bind the new one
+	BindPolicyRejectNew                   // keep the old msgQue, refuse to bind the new one
+	BindPolicyAllowN                      // allow at most MaxBind msgQues bound to the same key, kick the oldest when exceeded
+)
+
+// MsgQueBinder maps a user key to the msgQues logged in with it
+type MsgQueBinder struct {
+	Policy      BindPolicy
+	MaxBind     int           // only used by BindPolicyAllowN
+	KickErr     error         // error sent to the kicked msgQue, ErrMsgQueKicked if nil
+	KickTimeout time.Duration // time to flush KickErr before the kicked msgQue closes, DefKickTimeout if 0
+
+	binds map[interface{}][]IMsgQue
+	keys  map[uint32]interface{}
+	lock  sync.Mutex
+}
+
+// Bind binds msgQue to key and sets key as the user of msgQue
+func (r *MsgQueBinder) Bind(msgQue IMsgQue, key interface{}) (err error) {
+	if msgQue == nil {
+		return ErrMsgQueBindReject
+	}
+
+	var kicked []IMsgQue
+	bind := func() bool {
+		kicked, err = r.bind(msgQue, key)
+		return err == nil
+	}
+	// the stop check and the binding are done under the lock of msgQue, so BaseStop never misses the binding
+	if q, ok := msgQue.(interface {
+		setBinder(*MsgQueBinder, func() bool) bool
+	}); ok {
+		if !q.setBinder(r, bind) && err == nil {
+			err = ErrMsgQueBindReject
+		}
+	} else if msgQue.IsStop() {
+		err = ErrMsgQueBindReject
+	} else {
+		bind()
+	}
+	if err != nil {
+		return err
+	}
+
+	msgQue.SetUser(key)
+	for _, v := range kicked {
+		r.kick(v)
+	}
+	return nil
+}
+
+func (r *MsgQueBinder) bind(msgQue IMsgQue, key interface{}) ([]IMsgQue, error) {
+	var kicked []IMsgQue
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.binds == nil {
+		r.binds = map[interface{}][]IMsgQue{}
+		r.keys = map[uint32]interface{}{}
+	}
+	if old, ok := r.keys[msgQue.ID()]; ok {
+		if old == key {
+			return nil, nil
+		}
+		r.unbind(msgQue.ID(), old)
+	}
+
+	max := 1
+	if r.Policy == BindPolicyAllowN && r.MaxBind > 1 {
+		max = r.MaxBind
+	}
+	queues := r.binds[key]
+	if len(queues) >= max {
+		if r.Policy == BindPolicyRejectNew {
+			return nil, ErrMsgQueBindReject
+		}
+		n := len(queues) - max + 1
+		kicked = append(kicked, queues[:n]...)
+		for _, v := range kicked {
+			delete(r.keys, v.ID())
+		}
+		queues = append([]IMsgQue{}, queues[n:]...)
+	}
+	r.binds[key] = append(queues, msgQue)
+	r.keys[msgQue.ID()] = key
+	return kicked, nil
+}
+
+// Unbind removes the binding of msgQue, it is safe to call it more than once or from OnDelMsgQue
+func (r *MsgQueBinder) Unbind(msgQue IMsgQue) {
+	if msgQue == nil {
+		return
+	}
+	r.unbindID(msgQue.ID())
+}
+
+// Get returns the latest msgQue bound to key
+func (r *MsgQueBinder) Get(key interface{}) IMsgQue {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	queues := r.binds[key]
+	if len(queues) == 0 {
+		return nil
+	}
+	return queues[len(queues)-1]
+}
+
+// GetAll returns all msgQues bound to key
+func (r *MsgQueBinder) GetAll(key interface{}) []IMsgQue {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]IMsgQue{}, r.binds[key]...)
+}
+
+// GetKey returns the key msgQue bound to
+func (r *MsgQueBinder) GetKey(msgQue IMsgQue) (interface{}, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key, ok := r.keys[msgQue.ID()]
+	return key, ok
+}
+
+// Count returns the number of bound keys
+func (r *MsgQueBinder) Count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.binds)
+}
+
+func (r *MsgQueBinder) unbindID(id uint32) {
+	r.lock.Lock()
+	if key, ok := r.keys[id]; ok {
+		r.unbind(id, key)
+	}
+	r.lock.Unlock()
+}
+
+func (r *MsgQueBinder) unbind(id uint32, key interface{}) {
+	delete(r.keys, id)
+	queues := r.binds[key]
+	for i, v := range queues {
+		if v.ID() == id {
+			queues = append(queues[:i:i], queues[i+1:]...)
+			break
+		}
+	}
+	if len(queues) == 0 {
+		delete(r.binds, key)
+	} else {
+		r.binds[key] = queues
+	}
+}
+
+func (r *MsgQueBinder) kick(msgQue IMsgQue) {
+	err := r.KickErr
+	if err == nil {
+		err = ErrMsgQueKicked
+	}
+	Infof("msgQue:%d kicked by new login", msgQue.ID())
+	if msgQue.GetMsgType() == MsgTypeMsg {
+		msgQue.Send(NewErrMsg(err))
+	} else {
+		msgQue.SendStringLn(err.Error())
+	}
+	timeout := r.KickTimeout
+	if timeout <= 0 {
+		timeout = DefKickTimeout
+	}
+	msgQue.Drain(timeout)
+}
+
+var DefKickTimeout = time.Second // time to flush the kick reason to the kicked msgQue
+
+func NewMsgQueBinder(policy BindPolicy, maxBind int) *MsgQueBinder {
+	return &MsgQueBinder{
+		Policy:  policy,
+		MaxBind: maxBind,
+		binds:   map[interface{}][]IMsgQue{},
+		keys:    map[uint32]interface{}{},
+	}
+}
@@ -0,0 +1,90 @@
+package sugar
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestMsgQue() IMsgQue {
+	c, _ := net.Pipe()
+	return newTCPAccept(DefApp, c, MsgTypeMsg, &DefMsgHandler{}, nil, NewConnOptions())
+}
+
+func Test_MsgQueBinder(t *testing.T) {
+	binder := NewMsgQueBinder(BindPolicyKickOld, 0)
+	old, cur := newTestMsgQue(), newTestMsgQue()
+	if err := binder.Bind(old, 1001); err != nil {
+		t.Fatal(err)
+	}
+	if err := binder.Bind(cur, 1001); err != nil {
+		t.Fatal(err)
+	}
+	if binder.Get(1001) != cur || !old.IsStop() {
+		t.Fatal("old msgQue should be kicked")
+	}
+	binder.Unbind(old)
+	if binder.Get(1001) != cur {
+		t.Fatal("unbind of kicked msgQue should keep new binding")
+	}
+	cur.Stop()
+	deadline := time.Now().Add(2 * time.Second)
+	for binder.Count() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("stopped msgQue should be unbound")
+		}
+		Sleep(10)
+	}
+
+	binder = NewMsgQueBinder(BindPolicyRejectNew, 0)
+	old, cur = newTestMsgQue(), newTestMsgQue()
+	binder.Bind(old, 1002)
+	if err := binder.Bind(cur, 1002); err != ErrMsgQueBindReject {
+		t.Fatalf("want reject got %v", err)
+	}
+
+	binder = NewMsgQueBinder(BindPolicyAllowN, 2)
+	a, b, c := newTestMsgQue(), newTestMsgQue(), newTestMsgQue()
+	binder.Bind(a, 1003)
+	binder.Bind(b, 1003)
+	binder.Bind(c, 1003)
+	if len(binder.GetAll(1003)) != 2 || !a.IsStop() {
+		t.Fatal("oldest msgQue should be kicked")
+	}
+}
+
+func Test_MsgQueBinderKickReason(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := newTCPAccept(DefApp, c, MsgTypeMsg, &DefMsgHandler{}, nil, NewConnOptions())
+	old.init = true
+	old.available = true
+	Go(old.read)
+	Go(old.write)
+
+	binder := NewMsgQueBinder(BindPolicyKickOld, 0)
+	binder.Bind(old, 1001)
+	binder.Bind(newTestMsgQue(), 1001)
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	data, err := io.ReadAll(client)
+	if err != nil || len(data) < MsgHeadSize {
+		t.Fatalf("kick reason not received, len:%d err:%v", len(data), err)
+	}
+	if head := MessageHeadFromByte(data[:MsgHeadSize]); head == nil || GetError(head.Error) != ErrMsgQueKicked {
+		t.Fatalf("want kicked error got %v", head)
+	}
+}
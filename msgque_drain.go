@@ -0,0 +1,103 @@
+package sugar
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+func (r *msgQue) IsDraining() bool {
+	return atomic.LoadInt32(&r.drain) == 1
+}
+
+// drainTimeout returns 0 if Stop should not drain
+func (r *msgQue) drainTimeout() time.Duration {
+	if r.app.IsStop() && StopDrainTimeout > 0 {
+		return StopDrainTimeout
+	}
+	if r.opts != nil {
+		return r.opts.DrainTimeout
+	}
+	return 0
+}
+
+func (r *msgQue) startDrain() bool {
+	if atomic.LoadInt32(&r.stop) == 1 || !atomic.CompareAndSwapInt32(&r.drain, 0, 1) {
+		return false
+	}
+	atomic.AddInt32(&r.app.drainCount, 1)
+	return true
+}
+
+func (r *msgQue) endDrain() {
+	atomic.AddInt32(&r.app.drainCount, -1)
+}
+
+// waitFlush waits pending messages written, returns false if deadline reached or writer exited
+func (r *msgQue) waitFlush(deadline time.Time) bool {
+	for atomic.LoadInt32(&r.wstat.queuedMsgs) > 0 {
+		if atomic.LoadInt32(&r.writeExit) == 1 || !time.Now().Before(deadline) {
+			return false
+		}
+		Sleep(1)
+	}
+	return true
+}
+
+func (r *tcpMsgQue) Drain(timeout time.Duration) {
+	if r.listener != nil || !r.available || atomic.LoadInt32(&r.connecting) == 1 {
+		r.stopNow()
+		return
+	}
+	if !r.startDrain() {
+		return
+	}
+	r.app.Go(func() {
+		defer r.endDrain()
+		deadline := time.Now().Add(timeout)
+		if !r.waitFlush(deadline) {
+			Warnf("msgQue:%d drain incomplete, queued msgs:%d", r.id, atomic.LoadInt32(&r.wstat.queuedMsgs))
+		}
+		// half close, so the remote reads all data before EOF
+		if r.poll != nil {
+			r.poll.closeWrite()
+		} else if c, ok := r.conn.(closeWriter); ok {
+			c.CloseWrite()
+		}
+		for atomic.LoadInt32(&r.readExit) == 0 && time.Now().Before(deadline) {
+			Sleep(1)
+		}
+		r.stopNow()
+	})
+}
+
+func (r *udpMsgQue) Drain(timeout time.Duration) {
+	if r.connTyp == ConnTypeListen || r.writeCh == nil || !r.available {
+		r.stopNow()
+		return
+	}
+	if !r.startDrain() {
+		return
+	}
+	r.app.Go(func() {
+		defer r.endDrain()
+		if !r.waitFlush(time.Now().Add(timeout)) {
+			Warnf("msgQue:%d drain incomplete, queued msgs:%d", r.id, atomic.LoadInt32(&r.wstat.queuedMsgs))
+		}
+		r.stopNow()
+	})
+}
+
+// waitDrain waits all draining msgQue of the app stopped
+func (r *App) waitDrain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout + time.Second)
+	for atomic.LoadInt32(&r.drainCount) > 0 && time.Now().Before(deadline) {
+		Sleep(1)
+	}
+}
+
+// StopDrainTimeout is used by Stop and WaitForSystemExit to flush pending messages of all connections, 0 disables
+var StopDrainTimeout = 3 * time.Second
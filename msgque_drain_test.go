@@ -0,0 +1,65 @@
+package sugar
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type drainTestHandler struct {
+	DefMsgHandler
+	del chan struct{}
+}
+
+func (r *drainTestHandler) OnDelMsgQue(msgQue IMsgQue) {
+	close(r.del)
+}
+
+func Test_MsgQueDrain(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := NewConnOptions()
+	opts.DrainTimeout = time.Second
+	handler := &drainTestHandler{del: make(chan struct{})}
+	msgQue := newTCPAccept(DefApp, c, MsgTypeMsg, handler, nil, opts)
+	msgQue.init = true
+	msgQue.available = true
+	Go(msgQue.read)
+	Go(msgQue.write)
+
+	const cnt = 200
+	for i := 0; i < cnt; i++ {
+		msgQue.Send(NewMsg(1, 1, uint16(i), 0, make([]byte, 1024)))
+	}
+	msgQue.Stop()
+	if msgQue.Send(NewMsg(1, 1, 0, 0, nil)) {
+		t.Fatal("send should fail while draining")
+	}
+
+	data, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != cnt*(MsgHeadSize+1024) {
+		t.Fatalf("want %d bytes got %d", cnt*(MsgHeadSize+1024), len(data))
+	}
+	client.Close()
+	select {
+	case <-handler.del:
+	case <-time.After(2 * time.Second):
+		t.Fatal("OnDelMsgQue not fired")
+	}
+}
@@ -0,0 +1,212 @@
+package sugar
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// AcceptFilter is invoked before OnNewMsgQue, return false to reject the connection
+type AcceptFilter func(addr net.Addr) bool
+
+type ConnLimit struct {
+	MaxConn            int // max accepted connections of all listeners, 0 means unlimited
+	MaxConnPerIP       int // max accepted connections from one ip, 0 means unlimited
+	MaxAcceptPerSecond int // max new connections per second, 0 means unlimited
+	Filter             AcceptFilter
+}
+
+type connLimiter struct {
+	ConnLimit
+	allow     []*net.IPNet
+	deny      []*net.IPNet
+	ipCount   map[string]int
+	connCount int
+	second    int64
+	accepted  int
+	stat      *Stat // reject counts are added to
+	sync.Mutex
+}
+
+// SetConnLimit replaces the accept limits, it can be invoked at runtime
+func SetConnLimit(limit ConnLimit) {
+	DefApp.SetConnLimit(limit)
+}
+
+func GetConnLimit() ConnLimit {
+	return DefApp.GetConnLimit()
+}
+
+// SetAllowCIDR only accepts connections from cidrs, empty means allow all
+func SetAllowCIDR(cidrs ...string) error {
+	return DefApp.SetAllowCIDR(cidrs...)
+}
+
+// SetDenyCIDR rejects connections from cidrs, deny list is checked before allow list
+func SetDenyCIDR(cidrs ...string) error {
+	return DefApp.SetDenyCIDR(cidrs...)
+}
+
+func (r *App) SetConnLimit(limit ConnLimit) {
+	r.limiter.Lock()
+	r.limiter.ConnLimit = limit
+	r.limiter.Unlock()
+}
+
+func (r *App) GetConnLimit() ConnLimit {
+	r.limiter.Lock()
+	defer r.limiter.Unlock()
+	return r.limiter.ConnLimit
+}
+
+func (r *App) SetAllowCIDR(cidrs ...string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	r.limiter.Lock()
+	r.limiter.allow = nets
+	r.limiter.Unlock()
+	return nil
+}
+
+func (r *App) SetDenyCIDR(cidrs ...string) error {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		return err
+	}
+	r.limiter.Lock()
+	r.limiter.deny = nets
+	r.limiter.Unlock()
+	return nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range cidrs {
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+func (r *connLimiter) matchCIDR(nets []*net.IPNet, ip net.IP) bool {
+	for _, v := range nets {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire checks whether a connection from addr can be accepted, and counts it if so
+func (r *connLimiter) acquire(addr net.Addr) (string, bool) {
+	ip := addrIP(addr)
+	r.Lock()
+	filter := r.Filter
+	if ip != nil && (r.matchCIDR(r.deny, ip) || (len(r.allow) > 0 && !r.matchCIDR(r.allow, ip))) {
+		r.Unlock()
+		atomic.AddInt32(&r.stat.RejectFilterCount, 1)
+		return "", false
+	}
+	r.Unlock()
+
+	if filter != nil && !filter(addr) {
+		atomic.AddInt32(&r.stat.RejectFilterCount, 1)
+		return "", false
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if r.MaxAcceptPerSecond > 0 {
+		if now := GetTimestamp(); r.second != now {
+			r.second = now
+			r.accepted = 0
+		}
+		if r.accepted >= r.MaxAcceptPerSecond {
+			atomic.AddInt32(&r.stat.RejectRateCount, 1)
+			return "", false
+		}
+	}
+	if r.MaxConn > 0 && r.connCount >= r.MaxConn {
+		atomic.AddInt32(&r.stat.RejectConnCount, 1)
+		return "", false
+	}
+	key := ""
+	if ip != nil {
+		key = ip.String()
+	}
+	if r.MaxConnPerIP > 0 && r.ipCount[key] >= r.MaxConnPerIP {
+		atomic.AddInt32(&r.stat.RejectIPCount, 1)
+		return "", false
+	}
+	r.accepted++
+	r.connCount++
+	r.ipCount[key]++
+	return key, true
+}
+
+func (r *connLimiter) release(key string) {
+	r.Lock()
+	r.releaseLocked(key)
+	r.Unlock()
+}
+
+// releaseRef releases *key, it is read under the lock since move may change it
+func (r *connLimiter) releaseRef(key *string) {
+	r.Lock()
+	r.releaseLocked(*key)
+	r.Unlock()
+}
+
+func (r *connLimiter) releaseLocked(key string) {
+	r.connCount--
+	if r.ipCount[key]--; r.ipCount[key] <= 0 {
+		delete(r.ipCount, key)
+	}
+}
+
+// move recounts a connection under the ip of addr when it migrates, false if the new ip is denied or full
+func (r *connLimiter) move(key *string, addr net.Addr) bool {
+	ip := addrIP(addr)
+	newKey := ""
+	if ip != nil {
+		newKey = ip.String()
+	}
+	r.Lock()
+	defer r.Unlock()
+	if newKey == *key {
+		return true
+	}
+	if ip != nil && (r.matchCIDR(r.deny, ip) || (len(r.allow) > 0 && !r.matchCIDR(r.allow, ip))) {
+		atomic.AddInt32(&r.stat.RejectFilterCount, 1)
+		return false
+	}
+	if r.MaxConnPerIP > 0 && r.ipCount[newKey] >= r.MaxConnPerIP {
+		atomic.AddInt32(&r.stat.RejectIPCount, 1)
+		return false
+	}
+	if r.ipCount[*key]--; r.ipCount[*key] <= 0 {
+		delete(r.ipCount, *key)
+	}
+	r.ipCount[newKey]++
+	*key = newKey
+	return true
+}
@@ -0,0 +1,71 @@
+package sugar
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_ConnLimiter(t *testing.T) {
+	app := NewApp()
+	app.SetConnLimit(ConnLimit{MaxConn: 3, MaxConnPerIP: 2})
+	a := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1}
+	b := &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1}
+	keyA, ok := app.limiter.acquire(a)
+	if !ok || keyA != "10.0.0.1" {
+		t.Fatal("first connection should be accepted")
+	}
+	app.limiter.acquire(a)
+	if _, ok := app.limiter.acquire(a); ok || app.stat.RejectIPCount != 1 {
+		t.Fatal("per ip cap not applied")
+	}
+	app.limiter.acquire(b)
+	if _, ok := app.limiter.acquire(&net.TCPAddr{IP: net.ParseIP("10.0.0.3")}); ok || app.stat.RejectConnCount != 1 {
+		t.Fatal("total cap not applied")
+	}
+
+	// the count follows a migrated connection, a migration to a full ip is refused
+	if !app.limiter.move(&keyA, b) || keyA != "10.0.0.2" || app.limiter.ipCount["10.0.0.1"] != 1 || app.limiter.ipCount["10.0.0.2"] != 2 {
+		t.Fatalf("move not counted %v", app.limiter.ipCount)
+	}
+	keyB := "10.0.0.1"
+	if app.limiter.move(&keyB, b) || keyB != "10.0.0.1" {
+		t.Fatal("move to a full ip should be refused")
+	}
+	app.limiter.releaseRef(&keyA)
+	if app.limiter.ipCount["10.0.0.2"] != 1 {
+		t.Fatal("released count should follow the moved key")
+	}
+
+	app.SetConnLimit(ConnLimit{Filter: func(addr net.Addr) bool { return addrIP(addr).Equal(a.IP) }})
+	if _, ok := app.limiter.acquire(b); ok || app.stat.RejectFilterCount != 1 {
+		t.Fatal("filter not applied")
+	}
+	if err := app.SetDenyCIDR("10.0.0.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := app.limiter.acquire(a); ok || app.stat.RejectFilterCount != 2 {
+		t.Fatal("deny cidr not applied")
+	}
+	if err := app.SetAllowCIDR("bad"); err == nil {
+		t.Fatal("bad cidr should fail")
+	}
+}
+
+func Test_ConnLimiterRate(t *testing.T) {
+	clock := NewManualClock(Now())
+	SetClock(clock)
+	defer SetClock(nil)
+	app := NewApp()
+	app.SetConnLimit(ConnLimit{MaxAcceptPerSecond: 2})
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1")}
+	app.limiter.acquire(addr)
+	app.limiter.acquire(addr)
+	if _, ok := app.limiter.acquire(addr); ok || app.stat.RejectRateCount != 1 {
+		t.Fatal("rate not applied")
+	}
+	clock.Advance(time.Second)
+	if _, ok := app.limiter.acquire(addr); !ok {
+		t.Fatal("rate should reset next second")
+	}
+}
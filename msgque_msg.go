@@ -1,8 +1,8 @@
 package sugar
 
 import (
+	"encoding/binary"
 	"fmt"
-	"unsafe"
 )
 
 const (
@@ -17,6 +17,7 @@ const (
 	FlagAck      = 1 << 4 //acknowledgement message
 	FlagReSend   = 1 << 5 //message been re-sent
 	FlagClient   = 1 << 6 //use this to tell the message source, internal server or published client
+	FlagCookie   = 1 << 7 //reserved for udp handshake datagrams, never set on messages
 )
 
 var MaxMsgDataSize uint32 = 1024 * 1024
@@ -38,26 +39,24 @@ func (r *MessageHead) Bytes() []byte {
 		return r.data
 	}
 	data := make([]byte, MsgHeadSize)
-	phead := (*MessageHead)(unsafe.Pointer(&data[0]))
-	phead.Len = r.Len
-	phead.Error = r.Error
-	phead.Cmd = r.Cmd
-	phead.Act = r.Act
-	phead.Index = r.Index
-	phead.Flags = r.Flags
+	r.put(data)
 	return data
 }
 
+// put encodes the head in little endian, the layout the head had in memory on x86 and arm
+func (r *MessageHead) put(data []byte) {
+	binary.LittleEndian.PutUint32(data, r.Len)
+	binary.LittleEndian.PutUint16(data[4:], r.Error)
+	data[6] = r.Cmd
+	data[7] = r.Act
+	binary.LittleEndian.PutUint16(data[8:], r.Index)
+	binary.LittleEndian.PutUint16(data[10:], r.Flags)
+}
+
 func (r *MessageHead) BytesWithData(wdata []byte) []byte {
 	r.Len = uint32(len(wdata))
 	data := make([]byte, MsgHeadSize+r.Len)
-	phead := (*MessageHead)(unsafe.Pointer(&data[0]))
-	phead.Len = r.Len
-	phead.Error = r.Error
-	phead.Cmd = r.Cmd
-	phead.Act = r.Act
-	phead.Index = r.Index
-	phead.Flags = r.Flags
+	r.put(data)
 	if wdata != nil {
 		copy(data[MsgHeadSize:], wdata)
 	}
@@ -68,13 +67,12 @@ func (r *MessageHead) FromBytes(data []byte) error {
 	if len(data) < MsgHeadSize {
 		return ErrMsgLenTooShort
 	}
-	phead := (*MessageHead)(unsafe.Pointer(&data[0]))
-	r.Len = phead.Len
-	r.Error = phead.Error
-	r.Cmd = phead.Cmd
-	r.Act = phead.Act
-	r.Index = phead.Index
-	r.Flags = phead.Flags
+	r.Len = binary.LittleEndian.Uint32(data)
+	r.Error = binary.LittleEndian.Uint16(data[4:])
+	r.Cmd = data[6]
+	r.Act = data[7]
+	r.Index = binary.LittleEndian.Uint16(data[8:])
+	r.Flags = binary.LittleEndian.Uint16(data[10:])
 	if r.Len > MaxMsgDataSize {
 		return ErrMsgLenTooLong
 	}
@@ -109,16 +107,9 @@ func NewMessageHead(data []byte) *MessageHead {
 	return head
 }
 
+// MessageHeadFromByte is NewMessageHead, the head is a copy but not a view of data
 func MessageHeadFromByte(data []byte) *MessageHead {
-	if len(data) < MsgHeadSize {
-		return nil
-	}
-	phead := new(*MessageHead)
-	*phead = (*MessageHead)(unsafe.Pointer(&data[0]))
-	if (*phead).Len > MaxMsgDataSize {
-		return nil
-	}
-	return *phead
+	return NewMessageHead(data)
 }
 
 type Message struct {
@@ -126,6 +117,8 @@ type Message struct {
 	Data       []byte       //message data
 	IMsgParser              //message parser
 	User       interface{}  //user self defined data
+
+	priority MsgPriority
 }
 
 func (r *Message) CmdAct() int {
@@ -0,0 +1,22 @@
+package sugar
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_MessageHeadBytes(t *testing.T) {
+	head := &MessageHead{Error: 0x0102, Cmd: 3, Act: 4, Index: 0x0506, Flags: FlagNeedAck}
+	data := head.BytesWithData([]byte("ab"))
+	want := []byte{2, 0, 0, 0, 2, 1, 3, 4, 6, 5, FlagNeedAck, 0, 'a', 'b'}
+	if !bytes.Equal(data, want) || !bytes.Equal(head.Bytes(), want[:MsgHeadSize]) {
+		t.Fatalf("encoded %v", data)
+	}
+	if got := NewMessageHead(data); got == nil || got.String() != head.String() {
+		t.Fatalf("decoded %v", got)
+	}
+	data[3] = 0xff
+	if NewMessageHead(data) != nil || MessageHeadFromByte(data[:MsgHeadSize-1]) != nil {
+		t.Fatal("bad head decoded")
+	}
+}
@@ -0,0 +1,292 @@
+package sugar
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type MuxProto int
+
+const (
+	MuxProtoUnknown   MuxProto = iota
+	MuxProtoMsg                // binary messages with message head
+	MuxProtoCmd                // text lines, like telnet
+	MuxProtoWebSocket          // websocket upgrade request
+	MuxProtoHTTP               // any other http request
+)
+
+type MuxRoute struct {
+	Handler IMsgHandler
+	Parser  *Parser
+	MsgType MsgType // only used by websocket route, MsgTypeMsg uses binary frames and MsgTypeCmd uses text frames
+}
+
+// MuxServer serves several protocols on one port, the protocol is sniffed from the first bytes of a connection
+type MuxServer struct {
+	Msg           *MuxRoute
+	Cmd           *MuxRoute // also used when client sends nothing within SniffTimeout
+	WebSocket     *MuxRoute
+	WebSocketPath string // empty means any path
+	HTTP          http.Handler
+	SniffTimeout  time.Duration
+
+	httpListener *muxListener // shared by all listen sockets of the server
+	listenCnt    int          // listen sockets not exited yet, httpListener is closed when it drops to 0
+	lock         sync.Mutex
+}
+
+var muxHTTPMethods = []string{"GET ", "POST ", "PUT ", "HEAD ", "DELETE ", "OPTIONS ", "PATCH ", "CONNECT ", "TRACE "}
+
+// sniffProto returns MuxProtoUnknown if data is not enough to decide
+func sniffProto(data []byte) MuxProto {
+	for _, m := range muxHTTPMethods {
+		if len(data) < len(m) && strings.HasPrefix(m, string(data)) {
+			return MuxProtoUnknown
+		}
+		if !bytes.HasPrefix(data, []byte(m)) {
+			continue
+		}
+		line := bytes.IndexByte(data, '\n')
+		if line < 0 {
+			return MuxProtoUnknown
+		}
+		if !bytes.Contains(data[:line], []byte(" HTTP/1.")) {
+			return MuxProtoCmd
+		}
+		end := bytes.Index(data, []byte("\r\n\r\n"))
+		if end < 0 {
+			return MuxProtoUnknown
+		}
+		header := strings.ToLower(string(data[:end]))
+		if m == "GET " && strings.Contains(header, "\nupgrade: websocket") {
+			return MuxProtoWebSocket
+		}
+		return MuxProtoHTTP
+	}
+
+	n := len(data)
+	if n > MsgHeadSize {
+		n = MsgHeadSize
+	}
+	for _, c := range data[:n] {
+		if (c < 0x20 && c != '\t' && c != '\r' && c != '\n') || c >= 0x7f {
+			return MuxProtoMsg
+		}
+		if c == '\n' {
+			return MuxProtoCmd
+		}
+	}
+	if len(data) >= MsgHeadSize {
+		return MuxProtoCmd
+	}
+	return MuxProtoUnknown
+}
+
+func (r *MuxServer) sniff(c net.Conn, br *bufio.Reader) MuxProto {
+	timeout := r.SniffTimeout
+	if timeout <= 0 {
+		timeout = DefMuxSniffTimeout
+	}
+	c.SetReadDeadline(time.Now().Add(timeout))
+	defer c.SetReadDeadline(time.Time{})
+
+	if _, err := br.Peek(1); err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return MuxProtoCmd
+		}
+		return MuxProtoUnknown
+	}
+	for {
+		data, _ := br.Peek(br.Buffered())
+		if proto := sniffProto(data); proto != MuxProtoUnknown {
+			return proto
+		}
+		if len(data) == br.Size() {
+			return MuxProtoHTTP
+		}
+		if _, err := br.Peek(len(data) + 1); err != nil {
+			data, _ = br.Peek(br.Buffered())
+			if bytes.HasPrefix(data, []byte("GET ")) || bytes.HasPrefix(data, []byte("POST ")) {
+				return MuxProtoHTTP
+			}
+			return MuxProtoCmd
+		}
+	}
+}
+
+// serve routes c, msg and cmd connections are driven by the reactor if enabled, websocket and http ones never are
+func (r *MuxServer) serve(app *App, c net.Conn, key string, opts *ConnOptions, reactor bool) {
+	// options only apply to the tcp connection, not to the wrappers below
+	opts.applyTCP(c)
+	br := bufio.NewReaderSize(c, 4096)
+	conn := &muxConn{Conn: c, reader: br}
+	proto := r.sniff(c, br)
+	Debugf("mux sniff addr:%s proto:%d", c.RemoteAddr().String(), proto)
+
+	switch {
+	case proto == MuxProtoMsg && r.Msg != nil:
+		startTCPAccept(app, conn, key, MsgTypeMsg, r.Msg.Handler, r.Msg.Parser, opts, reactor)
+	case proto == MuxProtoCmd && r.Cmd != nil:
+		startTCPAccept(app, conn, key, MsgTypeCmd, r.Cmd.Handler, r.Cmd.Parser, opts, reactor)
+	case proto == MuxProtoWebSocket && r.WebSocket != nil:
+		ws, err := acceptWebSocket(conn, br, r.WebSocketPath, r.WebSocket.MsgType)
+		if err != nil {
+			Debugf("websocket handshake failed addr:%s err:%v", c.RemoteAddr().String(), err)
+			c.Close()
+			app.limiter.release(key)
+			return
+		}
+		startTCPAccept(app, ws, key, r.WebSocket.MsgType, r.WebSocket.Handler, r.WebSocket.Parser, opts, false)
+	case (proto == MuxProtoHTTP || proto == MuxProtoWebSocket) && r.HTTP != nil:
+		conn.limitKey = key
+		conn.limiter = app.limiter
+		if listener := r.getHTTPListener(app, c.LocalAddr()); listener == nil || !listener.push(conn) {
+			conn.Close()
+		}
+	default:
+		c.Close()
+		app.limiter.release(key)
+	}
+}
+
+// getHTTPListener starts the http server on first use, nil is returned if all listen sockets exited
+func (r *MuxServer) getHTTPListener(app *App, addr net.Addr) *muxListener {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.listenCnt <= 0 {
+		return nil
+	}
+	if r.httpListener == nil {
+		listener := &muxListener{addr: addr, ch: make(chan net.Conn, 64), stopCh: make(chan struct{})}
+		server := &http.Server{Handler: r.HTTP}
+		app.Go(func() {
+			server.Serve(listener)
+		})
+		r.httpListener = listener
+	}
+	return r.httpListener
+}
+
+func (r *MuxServer) open() {
+	r.lock.Lock()
+	r.listenCnt++
+	r.lock.Unlock()
+}
+
+// close is invoked by each listen socket when it exits
+func (r *MuxServer) close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.listenCnt--; r.listenCnt > 0 {
+		return
+	}
+	if r.httpListener != nil {
+		r.httpListener.Close()
+		r.httpListener = nil
+	}
+}
+
+// StartMuxServer listens on a tcp address and routes each connection by the sniffed protocol,
+// ConnOptions apply to every accepted connection and ReactorMode to msg and cmd connections
+func StartMuxServer(addr string, mux *MuxServer, opts ...*ConnOptions) error {
+	return DefApp.StartMuxServer(addr, mux, opts...)
+}
+
+func (r *App) StartMuxServer(addr string, mux *MuxServer, opts ...*ConnOptions) error {
+	opt := connOptions(opts)
+	addrInfo := strings.Split(addr, "://")
+	if len(addrInfo) > 1 {
+		addr = addrInfo[1]
+	}
+	listeners, err := listenTCP(addr)
+	if err != nil {
+		Errorf("listen on %s failed, err:%s", addr, err)
+		return err
+	}
+	for _, listen := range listeners {
+		msgQue := newTCPListen(r, listen, MsgTypeMsg, &DefMsgHandler{}, nil, addr, opt)
+		msgQue.mux = mux
+		mux.open()
+		r.Go(func() {
+			cid := r.AddStopCheck("msgQue mux listen")
+			Debugf("process mux listen for msgQue:%d", msgQue.id)
+			msgQue.listen()
+			Debugf("process mux listen end for msgQue:%d", msgQue.id)
+			r.RemoveStopCheck(cid)
+		})
+	}
+	return nil
+}
+
+// muxConn replays the sniffed bytes before reading from the connection
+type muxConn struct {
+	net.Conn
+	reader   *bufio.Reader
+	limiter  *connLimiter // limitKey is released to it when closed
+	limitKey string
+	once     sync.Once
+}
+
+func (r *muxConn) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+func (r *muxConn) Close() error {
+	if r.limiter != nil {
+		r.once.Do(func() {
+			r.limiter.release(r.limitKey)
+		})
+	}
+	return r.Conn.Close()
+}
+
+func (r *muxConn) CloseWrite() error {
+	if c, ok := r.Conn.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
+
+// muxListener hands sniffed http connections to http.Server
+type muxListener struct {
+	addr     net.Addr
+	ch       chan net.Conn
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func (r *muxListener) push(c net.Conn) bool {
+	select {
+	case r.ch <- c:
+		return true
+	case <-r.stopCh:
+		return false
+	}
+}
+
+func (r *muxListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-r.ch:
+		return c, nil
+	case <-r.stopCh:
+		return nil, ErrMuxListenerClosed
+	}
+}
+
+func (r *muxListener) Close() error {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+	})
+	return nil
+}
+
+func (r *muxListener) Addr() net.Addr {
+	return r.addr
+}
+
+var DefMuxSniffTimeout = 5 * time.Second
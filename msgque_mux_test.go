@@ -0,0 +1,113 @@
+package sugar
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_SniffProto(t *testing.T) {
+	cases := map[string]MuxProto{
+		"GET / HTTP/1.1\r\nHost: x\r\n\r\n":              MuxProtoHTTP,
+		"GET /ws HTTP/1.1\r\nUpgrade: websocket\r\n\r\n": MuxProtoWebSocket,
+		"GET / HTTP/1.1\r\nHost: x\r\n":                  MuxProtoUnknown,
+		"GET player 1001\n":                              MuxProtoCmd,
+		"help\r\n":                                       MuxProtoCmd,
+		"GE":                                             MuxProtoUnknown,
+		string(NewMsg(1, 2, 0, 0, []byte("abc")).Bytes()): MuxProtoMsg,
+	}
+	for data, want := range cases {
+		if got := sniffProto([]byte(data)); got != want {
+			t.Errorf("sniff %q got %d want %d", data, got, want)
+		}
+	}
+}
+
+func Test_MuxServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	mux := &MuxServer{
+		Msg:  &MuxRoute{Handler: &EchoMsgHandler{}},
+		Cmd:  &MuxRoute{Handler: &EchoMsgHandler{}},
+		HTTP: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) { w.Write([]byte("ok")) }),
+	}
+	ReactorMode = true
+	err = StartMuxServer("tcp://"+addr, mux, NewConnOptions().SetNoDelay(false))
+	ReactorMode = false
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write(NewMsg(1, 2, 0, 0, []byte("abc")).Bytes())
+	if msg := readTestMsg(t, c); msg.Head.Cmd != 1 || string(msg.Data) != "abc" {
+		t.Fatalf("bad msg echo %v %q", msg.Head, msg.Data)
+	}
+
+	c, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write([]byte("help\n"))
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if line, err := bufio.NewReader(c).ReadString('\n'); err != nil || line != "help\n" {
+		t.Fatalf("bad cmd echo %q %v", line, err)
+	}
+
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Fatalf("bad http body %q", body)
+	}
+}
+
+func Test_MuxHTTPListenerShared(t *testing.T) {
+	mux := &MuxServer{HTTP: http.NotFoundHandler()}
+	mux.open()
+	mux.open()
+	listener := mux.getHTTPListener(DefApp, nil)
+	mux.close()
+	if mux.getHTTPListener(DefApp, nil) != listener {
+		t.Fatal("http listener closed while another listen socket is alive")
+	}
+	mux.close()
+	if mux.getHTTPListener(DefApp, nil) != nil {
+		t.Fatal("http listener should not be started after all listen sockets exited")
+	}
+	select {
+	case <-listener.stopCh:
+	default:
+		t.Fatal("http listener should be closed by the last listen socket")
+	}
+}
+
+func readTestMsg(t *testing.T, c net.Conn) *Message {
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	head := make([]byte, MsgHeadSize)
+	if _, err := io.ReadFull(c, head); err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{Head: NewMessageHead(head)}
+	msg.Data = make([]byte, msg.Head.Len)
+	if _, err := io.ReadFull(c, msg.Data); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
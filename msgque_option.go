@@ -0,0 +1,118 @@
+package sugar
+
+import (
+	"net"
+	"time"
+)
+
+// ConnOptions of zero value keeps system defaults, go enables TCP_NODELAY and 15s keepalive for tcp
+type ConnOptions struct {
+	NoDelay      *bool         // TCP_NODELAY, nil keeps system default, see SetNoDelay
+	KeepAlive    time.Duration // tcp keepalive period, 0 keeps system default, negative disables keepalive
+	ReadBuffer   int           // SO_RCVBUF, 0 keeps system default
+	WriteBuffer  int           // SO_SNDBUF, 0 keeps system default
+	Linger       *int          // SO_LINGER seconds, nil keeps system default, see SetLinger
+	DialTimeout  time.Duration // used by StartConnect, 0 means DefDialTimeout, negative means no timeout
+	ReadTimeout  time.Duration // 0 means DefMsgQueTimeout seconds, negative means no timeout
+	WriteTimeout time.Duration // 0 means DefMsgQueTimeout seconds, negative means no timeout
+	DrainTimeout time.Duration // Stop flushes pending messages within it before closing, 0 closes immediately
+
+	SlowQueueBytes int           // report slow consumer when queued outbound bytes exceed it, 0 disables
+	SlowWriteStall time.Duration // report slow consumer when nothing written for it while messages queued, 0 disables
+	SlowKick       bool          // stop slow consumer after reported
+	SlowDrop       bool          // Send drops the message and reports slow consumer instead of blocking when the queue is full
+}
+
+// NewConnOptions returns a copy of DefConnOptions to be modified
+func NewConnOptions() *ConnOptions {
+	opts := DefConnOptions
+	return &opts
+}
+
+// SetNoDelay sets TCP_NODELAY
+func (r *ConnOptions) SetNoDelay(noDelay bool) *ConnOptions {
+	r.NoDelay = &noDelay
+	return r
+}
+
+// SetLinger sets SO_LINGER, 0 discards unsent data and resets the connection on close
+func (r *ConnOptions) SetLinger(sec int) *ConnOptions {
+	r.Linger = &sec
+	return r
+}
+
+func (r *ConnOptions) dialTimeout() time.Duration {
+	if r.DialTimeout == 0 {
+		return DefDialTimeout
+	}
+	if r.DialTimeout < 0 {
+		return 0
+	}
+	return r.DialTimeout
+}
+
+func (r *ConnOptions) readTimeout() time.Duration {
+	return optionTimeout(r.ReadTimeout)
+}
+
+func (r *ConnOptions) writeTimeout() time.Duration {
+	return optionTimeout(r.WriteTimeout)
+}
+
+func optionTimeout(t time.Duration) time.Duration {
+	if t == 0 {
+		return time.Duration(DefMsgQueTimeout) * time.Second
+	}
+	if t < 0 {
+		return 0
+	}
+	return t
+}
+
+func (r *ConnOptions) applyTCP(conn net.Conn) {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if r.NoDelay != nil {
+		tcp.SetNoDelay(*r.NoDelay)
+	}
+	if r.KeepAlive > 0 {
+		tcp.SetKeepAlive(true)
+		tcp.SetKeepAlivePeriod(r.KeepAlive)
+	} else if r.KeepAlive < 0 {
+		tcp.SetKeepAlive(false)
+	}
+	if r.ReadBuffer > 0 {
+		tcp.SetReadBuffer(r.ReadBuffer)
+	}
+	if r.WriteBuffer > 0 {
+		tcp.SetWriteBuffer(r.WriteBuffer)
+	}
+	if r.Linger != nil {
+		tcp.SetLinger(*r.Linger)
+	}
+}
+
+func (r *ConnOptions) applyUDP(conn *net.UDPConn) {
+	readBuffer, writeBuffer := r.ReadBuffer, r.WriteBuffer
+	if readBuffer <= 0 {
+		readBuffer = 1 << 24
+	}
+	if writeBuffer <= 0 {
+		writeBuffer = 1 << 24
+	}
+	conn.SetReadBuffer(readBuffer)
+	conn.SetWriteBuffer(writeBuffer)
+}
+
+func connOptions(opts []*ConnOptions) *ConnOptions {
+	if len(opts) > 0 && opts[0] != nil {
+		return opts[0]
+	}
+	return NewConnOptions()
+}
+
+var DefConnOptions = ConnOptions{}
+
+var DefDialTimeout = time.Second
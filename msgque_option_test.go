@@ -0,0 +1,57 @@
+package sugar
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_ConnOptionsDefault(t *testing.T) {
+	var opts ConnOptions
+	if opts.NoDelay != nil || opts.Linger != nil || opts.KeepAlive != 0 {
+		t.Fatal("zero options should keep system defaults")
+	}
+	if opts.dialTimeout() != DefDialTimeout || opts.readTimeout() != time.Duration(DefMsgQueTimeout)*time.Second {
+		t.Fatal("zero timeouts should mean defaults")
+	}
+	opts.DialTimeout, opts.ReadTimeout = -1, -1
+	if opts.dialTimeout() != 0 || opts.readTimeout() != 0 {
+		t.Fatal("negative timeouts should mean no timeout")
+	}
+	opts.SetNoDelay(false).SetLinger(0)
+	if *opts.NoDelay || *opts.Linger != 0 || DefConnOptions.NoDelay != nil {
+		t.Fatal("setters should only change the copy")
+	}
+}
+
+func Test_UDPSessionTimeout(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 5000}
+	app := NewApp()
+
+	none, short := NewConnOptions(), NewConnOptions()
+	none.ReadTimeout = -1
+	short.ReadTimeout = 300 * time.Millisecond
+	noneQue := newUDPAccept(app, conn, MsgTypeMsg, &DefMsgHandler{}, nil, addr, 0, false, none)
+	shortQue := newUDPAccept(app, conn, MsgTypeMsg, &DefMsgHandler{}, nil, addr, 0, false, short)
+	defer noneQue.Stop()
+
+	Sleep(100)
+	if noneQue.IsStop() || shortQue.IsStop() {
+		t.Fatal("session stopped before timeout")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !shortQue.IsStop() {
+		if time.Now().After(deadline) {
+			t.Fatal("sub second timeout should stop the session")
+		}
+		Sleep(10)
+	}
+	if noneQue.IsStop() {
+		t.Fatal("session without timeout stopped")
+	}
+}
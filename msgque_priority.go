@@ -0,0 +1,96 @@
+package sugar
+
+type MsgPriority int
+
+const (
+	MsgPriorityNormal MsgPriority = iota // default lane
+	MsgPriorityHigh                      // latency critical messages, like combat updates
+	MsgPriorityLow                       // bulk messages, like full data sync
+	msgPriorityCnt
+)
+
+// lanes are drained in this order
+var msgPriorityOrder = [msgPriorityCnt]MsgPriority{MsgPriorityHigh, MsgPriorityNormal, MsgPriorityLow}
+
+// priorityPicker chooses the next lane to write, a pending lane skipped more than
+// MsgPriorityStarveLimit times in a row is served once even if higher lanes are not empty
+type priorityPicker struct {
+	skip [msgPriorityCnt]int
+}
+
+// pick returns -1 if all lanes are empty
+func (r *priorityPicker) pick(pending [msgPriorityCnt]int) MsgPriority {
+	sel := MsgPriority(-1)
+	for _, p := range msgPriorityOrder {
+		if pending[p] == 0 {
+			r.skip[p] = 0
+			continue
+		}
+		if sel < 0 {
+			sel = p
+			continue
+		}
+		r.skip[p]++
+		if r.skip[p] > MsgPriorityStarveLimit {
+			sel = p
+		}
+	}
+	if sel >= 0 {
+		r.skip[sel] = 0
+	}
+	return sel
+}
+
+func (r *msgQue) lane(p MsgPriority) chan *Message {
+	if p == MsgPriorityHigh && r.highCh != nil {
+		return r.highCh
+	}
+	if p == MsgPriorityLow && r.lowCh != nil {
+		return r.lowCh
+	}
+	return r.writeCh
+}
+
+// pickLane returns the lane to receive from without blocking, nil if all lanes are empty,
+// only the write goroutine invokes it
+func (r *msgQue) pickLane() chan *Message {
+	p := r.picker.pick([msgPriorityCnt]int{
+		MsgPriorityNormal: len(r.writeCh),
+		MsgPriorityHigh:   len(r.highCh),
+		MsgPriorityLow:    len(r.lowCh),
+	})
+	if p < 0 {
+		return nil
+	}
+	return r.lane(p)
+}
+
+// nextMsg blocks until a message is available, nil means woken up by Stop or lanes closed
+func (r *msgQue) nextMsg() *Message {
+	if ch := r.pickLane(); ch != nil {
+		return <-ch
+	}
+	select {
+	case m := <-r.highCh:
+		return m
+	case m := <-r.writeCh:
+		return m
+	case m := <-r.lowCh:
+		return m
+	}
+}
+
+func (r *Message) Priority() MsgPriority {
+	return r.priority
+}
+
+// SetPriority chooses the write lane of the message, it is not sent to the remote
+func (r *Message) SetPriority(p MsgPriority) *Message {
+	if p >= 0 && p < msgPriorityCnt {
+		r.priority = p
+	}
+	return r
+}
+
+var MsgPriorityStarveLimit = 16
+var MsgPriorityLaneSize = 64 // buffer size of high and low lanes, normal lane keeps the size of writeCh
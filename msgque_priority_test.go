@@ -0,0 +1,31 @@
+package sugar
+
+import "testing"
+
+func Test_PriorityPicker(t *testing.T) {
+	var picker priorityPicker
+	pending := [msgPriorityCnt]int{MsgPriorityNormal: 100, MsgPriorityHigh: 100, MsgPriorityLow: 100}
+	served := [msgPriorityCnt]int{}
+	for i := 0; i < 60; i++ {
+		p := picker.pick(pending)
+		served[p]++
+	}
+	if served[MsgPriorityHigh] <= served[MsgPriorityNormal] || served[MsgPriorityNormal] == 0 || served[MsgPriorityLow] == 0 {
+		t.Fatalf("unexpected serve count %v", served)
+	}
+	if picker.pick([msgPriorityCnt]int{}) != -1 {
+		t.Fatal("empty lanes should return -1")
+	}
+
+	msgQue := newTestMsgQue().(*tcpMsgQue)
+	msgQue.available = true
+	msgQue.Send(NewMsg(1, 1, 0, 0, nil).SetPriority(MsgPriorityLow))
+	msgQue.Send(NewMsg(1, 2, 0, 0, nil))
+	msgQue.Send(NewMsg(1, 3, 0, 0, nil).SetPriority(MsgPriorityHigh))
+	for _, act := range []uint8{3, 2, 1} {
+		if m := msgQue.nextMsg(); m.Act() != act {
+			t.Fatalf("want act %d got %d", act, m.Act())
+		}
+	}
+	msgQue.Stop()
+}
@@ -0,0 +1,589 @@
+//go:build linux
+// +build linux
+
+package sugar
+
+import (
+	"bytes"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// pollConn is an accepted tcp connection driven by a poller instead of read and write goroutines
+type pollConn struct {
+	fd       int
+	msgQue   *tcpMsgQue
+	poller   *poller
+	inBuf    []byte
+	outBuf   []byte                   // data being written
+	outQue   [msgPriorityCnt][][]byte // queued messages of each lane, used when socket is not writable
+	outLen   int
+	picker   priorityPicker
+	waitOut  bool
+	rdDone   bool // remote closed while draining, only writable events are watched
+	closed   bool
+	paused   bool // reading stopped until the handler catches up
+	lastRead int64
+	inMsgs   []*Message // parsed messages waiting for the handler
+	running  bool       // a goroutine is running the handler
+	eof      bool       // remote closed, close after inMsgs are handled
+	procLock sync.Mutex
+	sync.Mutex
+}
+
+// pollNetConn replaces the conn of a msgQue handed to a poller, the socket belongs to the dup fd then
+type pollNetConn struct {
+	conn   *pollConn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (r *pollNetConn) Read(b []byte) (int, error)         { return 0, ErrReactorConn }
+func (r *pollNetConn) Write(b []byte) (int, error)        { return 0, ErrReactorConn }
+func (r *pollNetConn) Close() error                       { r.conn.close(); return nil }
+func (r *pollNetConn) LocalAddr() net.Addr                { return r.local }
+func (r *pollNetConn) RemoteAddr() net.Addr               { return r.remote }
+func (r *pollNetConn) SetDeadline(t time.Time) error      { return nil }
+func (r *pollNetConn) SetReadDeadline(t time.Time) error  { return nil }
+func (r *pollNetConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (r *pollConn) read(buf []byte) bool {
+	for {
+		n, err := syscall.Read(r.fd, buf)
+		if n > 0 {
+			r.inBuf = append(r.inBuf, buf[:n]...)
+			if n < len(buf) {
+				break
+			}
+			continue
+		}
+		if err == syscall.EAGAIN {
+			break
+		}
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			Errorf("msgQue:%v recv data err:%v", r.msgQue.id, err)
+		}
+		return false
+	}
+	r.lastRead = GetNowTick()
+	return r.parse()
+}
+
+func (r *pollConn) parse() bool {
+	ok := true
+	if r.msgQue.msgTyp == MsgTypeCmd {
+		ok = r.parseCmd()
+	} else {
+		ok = r.parseMsg()
+	}
+	if len(r.inBuf) == 0 {
+		r.inBuf = nil
+	}
+	return ok
+}
+
+func (r *pollConn) parseMsg() bool {
+	msgQue := r.msgQue
+	for len(r.inBuf) >= MsgHeadSize && !msgQue.IsStop() {
+		head := NewMessageHead(r.inBuf)
+		if head == nil {
+			Errorf("msgQue:%v read msg head failed", msgQue.id)
+			return false
+		}
+		size := MsgHeadSize + int(head.Len)
+		if len(r.inBuf) < size {
+			break
+		}
+		msg := &Message{Head: head}
+		if head.Len > 0 {
+			msg.Data = append([]byte(nil), r.inBuf[MsgHeadSize:size]...)
+		}
+		r.inBuf = r.inBuf[size:]
+		if !r.deliver(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *pollConn) parseCmd() bool {
+	msgQue := r.msgQue
+	for !msgQue.IsStop() {
+		i := bytes.IndexByte(r.inBuf, '\n')
+		if i < 0 {
+			return len(r.inBuf) <= int(MaxMsgDataSize)
+		}
+		data := append([]byte(nil), r.inBuf[:i+1]...)
+		r.inBuf = r.inBuf[i+1:]
+		if !r.deliver(&Message{Data: data}) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *pollConn) process(msg *Message) bool {
+	if !r.msgQue.processMsg(r.msgQue, msg) {
+		if msg.Head != nil {
+			Errorf("msgQue:%v process msg cmd:%v act:%v", r.msgQue.id, msg.Head.Cmd, msg.Head.Act)
+		}
+		return false
+	}
+	return true
+}
+
+// deliver hands msg to the handler goroutine of the connection, so a slow handler never blocks the poller,
+// messages of a connection are still processed in order, reading pauses while ReactorMaxPendingMsgs are waiting
+func (r *pollConn) deliver(msg *Message) bool {
+	if ReactorInlineHandler {
+		return r.process(msg)
+	}
+	r.procLock.Lock()
+	defer r.procLock.Unlock()
+	r.inMsgs = append(r.inMsgs, msg)
+	if ReactorMaxPendingMsgs > 0 && len(r.inMsgs) >= ReactorMaxPendingMsgs {
+		r.pause(true)
+	}
+	if !r.running {
+		r.running = true
+		r.poller.app.Go(r.handle)
+	}
+	return true
+}
+
+func (r *pollConn) handle() {
+	for {
+		r.procLock.Lock()
+		msgs := r.inMsgs
+		r.inMsgs = nil
+		if len(msgs) == 0 {
+			r.running = false
+			eof := r.eof
+			r.procLock.Unlock()
+			if eof {
+				r.close()
+				r.msgQue.Stop()
+			}
+			return
+		}
+		r.pause(false)
+		r.procLock.Unlock()
+		for _, msg := range msgs {
+			if r.msgQue.IsStop() {
+				break
+			}
+			if !r.handleMsg(msg) {
+				r.close()
+				r.msgQue.Stop()
+				break
+			}
+		}
+	}
+}
+
+// finish closes the connection after remote closed, messages received before are still handled
+func (r *pollConn) finish() {
+	r.procLock.Lock()
+	if r.running {
+		r.eof = true
+		r.procLock.Unlock()
+		r.stopRead()
+		return
+	}
+	r.procLock.Unlock()
+	r.close()
+	r.msgQue.Stop()
+}
+
+func (r *pollConn) handleMsg(msg *Message) (ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			Errorf("msgQue process panic id:%v err:%v", r.msgQue.id, err)
+			LogStack()
+			ok = false
+		}
+	}()
+	return r.process(msg)
+}
+
+func (r *pollConn) pause(paused bool) {
+	r.Lock()
+	defer r.Unlock()
+	if r.paused != paused {
+		r.paused = paused
+		r.watch()
+	}
+}
+
+// watch updates the events watched by the poller, must be invoked with lock held
+func (r *pollConn) watch() {
+	if r.closed {
+		return
+	}
+	if r.rdDone {
+		if r.waitOut {
+			r.poller.modify(r.fd, syscall.EPOLLOUT)
+		} else {
+			r.poller.remove(r.fd)
+		}
+		return
+	}
+	events := uint32(syscall.EPOLLRDHUP)
+	if !r.paused {
+		events |= syscall.EPOLLIN
+	}
+	if r.waitOut {
+		events |= syscall.EPOLLOUT
+	}
+	r.poller.modify(r.fd, events)
+}
+
+func (r *pollConn) send(m *Message) bool {
+	var data []byte
+	if r.msgQue.msgTyp == MsgTypeCmd {
+		data = m.Data
+	} else {
+		data = m.Bytes()
+	}
+	if len(data) == 0 {
+		return true
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.closed {
+		return false
+	}
+	if r.outLen+len(data) > ReactorMaxWriteBuf {
+		Errorf("msgQue:%v write buffer full", r.msgQue.id)
+		return false
+	}
+	r.outLen += len(data)
+	defer r.msgQue.checkQueued(r.msgQue.queued(len(data)))
+	if len(r.outBuf) == 0 {
+		r.outBuf = data
+	} else {
+		r.outQue[m.priority] = append(r.outQue[m.priority], data)
+	}
+	if r.waitOut {
+		return true
+	}
+	return r.flush()
+}
+
+// next moves the next queued message into outBuf, must be invoked with lock held
+func (r *pollConn) next() bool {
+	p := r.picker.pick([msgPriorityCnt]int{
+		MsgPriorityNormal: len(r.outQue[MsgPriorityNormal]),
+		MsgPriorityHigh:   len(r.outQue[MsgPriorityHigh]),
+		MsgPriorityLow:    len(r.outQue[MsgPriorityLow]),
+	})
+	if p < 0 {
+		return false
+	}
+	r.outBuf = r.outQue[p][0]
+	r.outQue[p][0] = nil
+	r.outQue[p] = r.outQue[p][1:]
+	if len(r.outQue[p]) == 0 {
+		r.outQue[p] = nil
+	}
+	return true
+}
+
+// flush must be invoked with lock held
+func (r *pollConn) flush() bool {
+	for len(r.outBuf) > 0 || r.next() {
+		n, err := syscall.Write(r.fd, r.outBuf)
+		if n > 0 {
+			r.outBuf = r.outBuf[n:]
+			r.outLen -= n
+			r.msgQue.written(n, len(r.outBuf) == 0)
+		}
+		if err == syscall.EAGAIN {
+			if !r.waitOut {
+				r.waitOut = true
+				r.watch()
+			}
+			return true
+		}
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			Errorf("msgQue write id:%v err:%v", r.msgQue.id, err)
+			atomic.StoreInt32(&r.msgQue.writeExit, 1)
+			r.msgQue.Stop()
+			return false
+		}
+	}
+	r.outBuf = nil
+	if r.waitOut {
+		r.waitOut = false
+		r.watch()
+	}
+	return true
+}
+
+// stopRead keeps a draining connection writable after remote closed
+func (r *pollConn) stopRead() {
+	r.Lock()
+	defer r.Unlock()
+	atomic.StoreInt32(&r.msgQue.readExit, 1)
+	if r.closed || r.rdDone {
+		return
+	}
+	r.rdDone = true
+	r.watch()
+}
+
+func (r *pollConn) closeWrite() {
+	r.Lock()
+	defer r.Unlock()
+	if !r.closed {
+		syscall.Shutdown(r.fd, syscall.SHUT_WR)
+	}
+}
+
+func (r *pollConn) close() {
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return
+	}
+	r.closed = true
+	r.outBuf = nil
+	atomic.StoreInt32(&r.msgQue.readExit, 1)
+	atomic.StoreInt32(&r.msgQue.writeExit, 1)
+	r.outQue = [msgPriorityCnt][][]byte{}
+	r.outLen = 0
+	r.Unlock()
+	syscall.Shutdown(r.fd, syscall.SHUT_RDWR)
+	r.poller.del(r)
+}
+
+type poller struct {
+	app       *App
+	epfd      int
+	conns     map[int]*pollConn
+	closing   []int // fds are only closed by the poller goroutine, so they can't be reused while being read
+	lastCheck int64
+	lock      sync.Mutex
+}
+
+func (r *poller) add(conn *pollConn) error {
+	r.lock.Lock()
+	r.conns[conn.fd] = conn
+	r.lock.Unlock()
+	err := syscall.EpollCtl(r.epfd, syscall.EPOLL_CTL_ADD, conn.fd, &syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(conn.fd)})
+	if err == syscall.EEXIST {
+		// added by watch of a send before, events are up to date
+		err = nil
+	}
+	if err != nil {
+		r.lock.Lock()
+		delete(r.conns, conn.fd)
+		r.lock.Unlock()
+	}
+	return err
+}
+
+func (r *poller) modify(fd int, events uint32) {
+	ev := &syscall.EpollEvent{Events: events, Fd: int32(fd)}
+	if syscall.EpollCtl(r.epfd, syscall.EPOLL_CTL_MOD, fd, ev) == syscall.ENOENT {
+		// removed after remote closed, a draining write needs it back
+		syscall.EpollCtl(r.epfd, syscall.EPOLL_CTL_ADD, fd, ev)
+	}
+}
+
+func (r *poller) remove(fd int) {
+	syscall.EpollCtl(r.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+func (r *poller) del(conn *pollConn) {
+	r.lock.Lock()
+	if r.conns[conn.fd] == conn {
+		delete(r.conns, conn.fd)
+	}
+	r.closing = append(r.closing, conn.fd)
+	r.lock.Unlock()
+	syscall.EpollCtl(r.epfd, syscall.EPOLL_CTL_DEL, conn.fd, nil)
+}
+
+func (r *poller) closeFds() {
+	r.lock.Lock()
+	closing := r.closing
+	r.closing = nil
+	r.lock.Unlock()
+	for _, fd := range closing {
+		syscall.Close(fd)
+	}
+}
+
+func (r *poller) get(fd int) *pollConn {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.conns[fd]
+}
+
+func (r *poller) loop() {
+	events := make([]syscall.EpollEvent, 256)
+	buf := make([]byte, 1<<16)
+	for r.app.IsRunning() || atomic.LoadInt32(&r.app.drainCount) > 0 {
+		n, err := syscall.EpollWait(r.epfd, events, 1000)
+		if err != nil && err != syscall.EINTR {
+			Errorf("reactor epoll wait err:%v", err)
+			break
+		}
+		for i := 0; i < n; i++ {
+			conn := r.get(int(events[i].Fd))
+			if conn == nil {
+				continue
+			}
+			ev := events[i].Events
+			if ev&syscall.EPOLLOUT != 0 {
+				conn.Lock()
+				conn.flush()
+				conn.Unlock()
+			}
+			if ev&(syscall.EPOLLIN|syscall.EPOLLRDHUP|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+				r.process(conn, buf)
+			}
+		}
+		r.checkTimeout()
+		r.closeFds()
+	}
+
+	r.lock.Lock()
+	var conns []*pollConn
+	for _, v := range r.conns {
+		conns = append(conns, v)
+	}
+	r.lock.Unlock()
+	for _, v := range conns {
+		v.close()
+		v.msgQue.Stop()
+	}
+	r.closeFds()
+}
+
+func (r *poller) process(conn *pollConn, buf []byte) {
+	defer func() {
+		if err := recover(); err != nil {
+			Errorf("msgQue read panic id:%v err:%v", conn.msgQue.id, err)
+			LogStack()
+			conn.close()
+			conn.msgQue.Stop()
+		}
+	}()
+	if !conn.read(buf) {
+		if conn.msgQue.IsDraining() {
+			conn.stopRead()
+			return
+		}
+		conn.finish()
+	}
+}
+
+func (r *poller) checkTimeout() {
+	if r.lastCheck == GetTimestamp() {
+		return
+	}
+	r.lastCheck = GetTimestamp()
+	var idle []*pollConn
+	r.lock.Lock()
+	for _, v := range r.conns {
+		if timeout := int64(v.msgQue.readTimeout / time.Millisecond); timeout > 0 && GetNowTick()-v.lastRead >= timeout {
+			idle = append(idle, v)
+		}
+	}
+	r.lock.Unlock()
+	for _, v := range idle {
+		Infof("msgQue:%d timeout", v.msgQue.id)
+		v.close()
+		v.msgQue.Stop()
+	}
+}
+
+func (r *App) startPollers() {
+	cnt := ReactorPollerCnt
+	if cnt <= 0 {
+		cnt = runtime.NumCPU()
+	}
+	for i := 0; i < cnt; i++ {
+		epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+		if err != nil {
+			Errorf("reactor epoll create err:%v", err)
+			continue
+		}
+		p := &poller{app: r, epfd: epfd, conns: map[int]*pollConn{}}
+		r.pollers = append(r.pollers, p)
+		r.Go(func() {
+			p.loop()
+			syscall.Close(p.epfd)
+		})
+	}
+}
+
+// startPollConn hands msgQue to a poller, returns false if reactor is unavailable
+func (r *App) startPollConn(msgQue *tcpMsgQue) bool {
+	r.pollerOnce.Do(r.startPollers)
+	if len(r.pollers) == 0 {
+		return false
+	}
+	c := msgQue.conn
+	var sniffed []byte
+	if mux, ok := c.(*muxConn); ok {
+		// bytes read by sniffing are parsed before the socket is polled
+		sniffed, _ = mux.reader.Peek(mux.reader.Buffered())
+		c = mux.Conn
+	}
+	tcp, ok := c.(*net.TCPConn)
+	if !ok {
+		return false
+	}
+	raw, err := tcp.SyscallConn()
+	if err != nil {
+		return false
+	}
+	fd := -1
+	raw.Control(func(s uintptr) {
+		fd, err = syscall.Dup(int(s))
+	})
+	if err != nil || fd < 0 {
+		Errorf("msgQue:%d reactor dup fd err:%v", msgQue.id, err)
+		return false
+	}
+	syscall.CloseOnExec(fd)
+	syscall.SetNonblock(fd, true)
+	// the dup owns the socket from now on
+	tcp.Close()
+
+	p := r.pollers[atomic.AddUint32(&r.pollerIndex, 1)%uint32(len(r.pollers))]
+	conn := &pollConn{fd: fd, msgQue: msgQue, poller: p, lastRead: GetNowTick()}
+	msgQue.conn = &pollNetConn{conn: conn, local: tcp.LocalAddr(), remote: tcp.RemoteAddr()}
+	msgQue.poll = conn
+	msgQue.writeCh = nil
+	msgQue.highCh = nil
+	msgQue.lowCh = nil
+	msgQue.sender = conn.send
+	msgQue.available = true
+	if len(sniffed) > 0 {
+		conn.inBuf = append([]byte(nil), sniffed...)
+		if !conn.parse() {
+			conn.close()
+			msgQue.Stop()
+			return true
+		}
+	}
+	if err := p.add(conn); err != nil {
+		Errorf("msgQue:%d reactor add err:%v", msgQue.id, err)
+		msgQue.Stop()
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package sugar
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const benchConnCount = 500
+
+func startBenchServer(b *testing.B, reactor bool) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	ReactorMode = reactor
+	err = StartServer("tcp://"+addr, MsgTypeMsg, &EchoMsgHandler{}, nil)
+	ReactorMode = false
+	if err != nil {
+		b.Fatal(err)
+	}
+	return addr
+}
+
+func msgQueCount() int {
+	return len(DefApp.MsgQues())
+}
+
+func memInuse() uint64 {
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	return m.HeapInuse + m.StackInuse
+}
+
+func benchmarkConnMemory(b *testing.B, reactor bool) {
+	addr := startBenchServer(b, reactor)
+	var total uint64
+	for i := 0; i < b.N; i++ {
+		base := msgQueCount()
+		before := memInuse()
+		conns := make([]net.Conn, 0, benchConnCount)
+		for j := 0; j < benchConnCount; j++ {
+			c, err := net.Dial("tcp", addr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			conns = append(conns, c)
+		}
+		for msgQueCount() < base+benchConnCount {
+			Sleep(1)
+		}
+		Sleep(100)
+		if after := memInuse(); after > before {
+			total += after - before
+		}
+		for _, c := range conns {
+			c.Close()
+		}
+		for msgQueCount() > base {
+			Sleep(1)
+		}
+	}
+	b.ReportMetric(float64(total)/float64(b.N*benchConnCount), "B/conn")
+}
+
+func Benchmark_ConnMemoryGoroutine(b *testing.B) {
+	benchmarkConnMemory(b, false)
+}
+
+func Benchmark_ConnMemoryReactor(b *testing.B) {
+	benchmarkConnMemory(b, true)
+}
+
+type reactorTestHandler struct {
+	DefMsgHandler
+	block  chan struct{}
+	remote chan string
+}
+
+func (r *reactorTestHandler) OnProcessMsg(msgQue IMsgQue, msg *Message) bool {
+	if msg.Head.Cmd == 1 {
+		<-r.block
+	}
+	r.remote <- msgQue.RemoteAddr()
+	msgQue.Send(msg)
+	return true
+}
+
+func startReactorPair(t *testing.T, handler IMsgHandler) (net.Conn, *tcpMsgQue) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgQue := newTCPAccept(DefApp, c, MsgTypeMsg, handler, nil, NewConnOptions())
+	msgQue.init = true
+	if !DefApp.startPollConn(msgQue) {
+		t.Fatal("reactor unavailable")
+	}
+	return client, msgQue
+}
+
+func Test_ReactorEcho(t *testing.T) {
+	ReactorPollerCnt = 1
+	defer func() { ReactorPollerCnt = 0 }()
+	handler := &reactorTestHandler{block: make(chan struct{}), remote: make(chan string, 4)}
+	slow, slowQue := startReactorPair(t, handler)
+	defer slow.Close()
+	fast, fastQue := startReactorPair(t, handler)
+	defer fast.Close()
+
+	slow.Write(NewMsg(1, 0, 0, 0, []byte("slow")).Bytes())
+	fast.Write(NewMsg(2, 0, 0, 0, []byte("fast")).Bytes())
+	if msg := readTestMsg(t, fast); string(msg.Data) != "fast" {
+		t.Fatalf("bad echo %q", msg.Data)
+	}
+	if remote := <-handler.remote; remote != fast.LocalAddr().String() {
+		t.Fatalf("remote addr %v want %v", remote, fast.LocalAddr())
+	}
+	close(handler.block)
+	if msg := readTestMsg(t, slow); string(msg.Data) != "slow" {
+		t.Fatalf("bad echo %q", msg.Data)
+	}
+	if slowQue.LocalAddr() != slow.RemoteAddr().String() {
+		t.Fatal("local addr lost after fd handoff")
+	}
+
+	fast.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for !fastQue.IsStop() {
+		if time.Now().After(deadline) {
+			t.Fatal("msgQue should stop after remote closed")
+		}
+		Sleep(1)
+	}
+	slowQue.Stop()
+	if _, err := slow.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("want EOF after stop got %v", err)
+	}
+}
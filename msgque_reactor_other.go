@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package sugar
+
+type poller struct{}
+
+type pollConn struct{}
+
+func (r *pollConn) close() {}
+
+func (r *pollConn) closeWrite() {}
+
+// startPollConn always returns false, reactor mode falls back to read and write goroutines
+func (r *App) startPollConn(msgQue *tcpMsgQue) bool {
+	return false
+}
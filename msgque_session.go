@@ -0,0 +1,328 @@
+package sugar
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+)
+
+type sessionMsg struct {
+	seq uint32
+	msg *Message
+}
+
+// MsgSession outlives the msgQue it is attached to,
+// messages sent by it have FlagNeedAck set and their data prefixed by a SessionSeqSize bytes little endian sequence,
+// Index is kept for request and response tags.
+// client acknowledges all messages up to a sequence by sending NewSessionAckMsg(seq),
+// messages replayed after resume are marked with FlagReSend
+type MsgSession struct {
+	token      string
+	user       interface{}
+	msgQue     IMsgQue
+	seq        uint32 // last sequence sent
+	acked      uint32 // last sequence acknowledged by client
+	buf        []sessionMsg
+	detachTick int64
+	manager    *SessionManager
+	lock       sync.Mutex
+}
+
+func (r *MsgSession) Token() string {
+	return r.token
+}
+
+func (r *MsgSession) GetUser() interface{} {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.user
+}
+
+func (r *MsgSession) SetUser(user interface{}) {
+	r.lock.Lock()
+	r.user = user
+	r.lock.Unlock()
+}
+
+func (r *MsgSession) GetMsgQue() IMsgQue {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.msgQue
+}
+
+// Send assigns a sequence to m, keeps it until acknowledged and sends it if a msgQue is attached
+func (r *MsgSession) Send(m *Message) (re bool) {
+	if m == nil || m.Head == nil {
+		return false
+	}
+	r.lock.Lock()
+	r.seq++
+	head := *m.Head
+	head.forever = false
+	head.Flags |= FlagNeedAck
+	data := make([]byte, SessionSeqSize+len(m.Data))
+	binary.LittleEndian.PutUint32(data, r.seq)
+	copy(data[SessionSeqSize:], m.Data)
+	head.Len = uint32(len(data))
+	sm := sessionMsg{seq: r.seq, msg: &Message{Head: &head, Data: data, User: m.User, priority: m.priority}}
+	if len(r.buf) >= r.manager.bufSize() {
+		r.buf = r.buf[1:]
+	}
+	r.buf = append(r.buf, sm)
+	msgQue := r.msgQue
+	r.lock.Unlock()
+
+	if msgQue != nil {
+		return msgQue.Send(sm.msg)
+	}
+	return true
+}
+
+// Ack releases all buffered messages up to seq
+func (r *MsgSession) Ack(seq uint32) {
+	r.lock.Lock()
+	r.ack(seq)
+	r.lock.Unlock()
+}
+
+func (r *MsgSession) ack(seq uint32) {
+	if seq <= r.acked || seq > r.seq {
+		return
+	}
+	r.acked = seq
+	i := 0
+	for i < len(r.buf) && r.buf[i].seq <= r.acked {
+		i++
+	}
+	r.buf = r.buf[i:]
+}
+
+// lostAfter reports whether messages after seq were dropped from buf, lock should be held
+func (r *MsgSession) lostAfter(seq uint32) bool {
+	if r.acked > seq {
+		seq = r.acked
+	}
+	return r.seq > seq && (len(r.buf) == 0 || r.buf[0].seq > seq+1)
+}
+
+// bufAfter copies buffered messages after seq, lock should be held
+func (r *MsgSession) bufAfter(seq uint32) []sessionMsg {
+	var msgs []sessionMsg
+	for _, v := range r.buf {
+		if v.seq > seq {
+			msgs = append(msgs, v)
+		}
+	}
+	return msgs
+}
+
+// Close removes the session and stops the attached msgQue
+func (r *MsgSession) Close() {
+	r.manager.remove(r)
+	r.lock.Lock()
+	msgQue := r.msgQue
+	r.msgQue = nil
+	r.buf = nil
+	r.lock.Unlock()
+	if msgQue != nil {
+		msgQue.Stop()
+	}
+}
+
+func (r *MsgSession) attach(msgQue IMsgQue) IMsgQue {
+	r.lock.Lock()
+	old := r.msgQue
+	r.msgQue = msgQue
+	r.lock.Unlock()
+	return r.swapSession(old, msgQue)
+}
+
+func (r *MsgSession) swapSession(old, msgQue IMsgQue) IMsgQue {
+	if q, ok := msgQue.(interface{ setSession(*MsgSession) }); ok {
+		q.setSession(r)
+	}
+	if old != nil && old != msgQue {
+		if q, ok := old.(interface{ setSession(*MsgSession) }); ok {
+			q.setSession(nil)
+		}
+		return old
+	}
+	return nil
+}
+
+func (r *MsgSession) detach(id uint32, app *App) {
+	r.lock.Lock()
+	if r.msgQue == nil || r.msgQue.ID() != id {
+		r.lock.Unlock()
+		return
+	}
+	r.msgQue = nil
+	r.detachTick = GetTimestamp()
+	r.lock.Unlock()
+
+	keepAlive := r.manager.keepAlive()
+	app.SetTimeout(keepAlive*1000, func(...interface{}) int {
+		r.lock.Lock()
+		expired := r.msgQue == nil && GetTimestamp()-r.detachTick >= int64(keepAlive)
+		r.lock.Unlock()
+		if expired && r.manager.remove(r) {
+			Infof("msgQue session expired token:%s", r.token)
+			if r.manager.OnExpire != nil {
+				r.manager.OnExpire(r)
+			}
+		}
+		return 0
+	})
+}
+
+type SessionManager struct {
+	BufSize   int                       // max unacknowledged messages kept per session
+	KeepAlive int                       // seconds a detached session is kept for resume
+	OnExpire  func(session *MsgSession) // invoked when a detached session is dropped
+
+	sessions map[string]*MsgSession
+	lock     sync.Mutex
+}
+
+// Create issues a new session token for msgQue, usually invoked after login
+func (r *SessionManager) Create(msgQue IMsgQue, user interface{}) *MsgSession {
+	session := &MsgSession{
+		token:   newSessionToken(),
+		user:    user,
+		manager: r,
+	}
+	r.lock.Lock()
+	if r.sessions == nil {
+		r.sessions = map[string]*MsgSession{}
+	}
+	r.sessions[session.token] = session
+	r.lock.Unlock()
+	session.attach(msgQue)
+	Infof("msgQue:%d new session token:%s", msgQue.ID(), session.token)
+	return session
+}
+
+// Resume reattaches msgQue to the session of token and replays messages after ack,
+// ErrSessionLost is returned if messages after ack were dropped, or if msgQue failed to send the replay
+// in which case the session is kept for another resume
+func (r *SessionManager) Resume(msgQue IMsgQue, token string, ack uint32) (*MsgSession, error) {
+	session := r.Get(token)
+	if session == nil {
+		return nil, ErrSessionNotFound
+	}
+
+	// replayed without the lock since Send may block, messages buffered meanwhile are replayed by the next round,
+	// msgQue is attached once nothing is left so the client receives them in order
+	session.lock.Lock()
+	session.ack(ack)
+	replay, sent := 0, session.acked
+	for {
+		if session.lostAfter(sent) {
+			session.lock.Unlock()
+			Infof("msgQue:%d resume session lost token:%s", msgQue.ID(), token)
+			session.Close()
+			return nil, ErrSessionLost
+		}
+		msgs := session.bufAfter(sent)
+		if len(msgs) == 0 {
+			break
+		}
+		session.lock.Unlock()
+		for _, v := range msgs {
+			head := *v.msg.Head
+			head.Flags |= FlagReSend
+			if !msgQue.Send(&Message{Head: &head, Data: v.msg.Data, User: v.msg.User, priority: v.msg.priority}) {
+				// the session is kept for another resume
+				Infof("msgQue:%d resume session replay failed token:%s", msgQue.ID(), token)
+				return nil, ErrSessionLost
+			}
+		}
+		replay += len(msgs)
+		sent = msgs[len(msgs)-1].seq
+		session.lock.Lock()
+	}
+	if r.Get(token) != session {
+		session.lock.Unlock()
+		return nil, ErrSessionLost
+	}
+	old := session.msgQue
+	session.msgQue = msgQue
+	session.lock.Unlock()
+
+	if old = session.swapSession(old, msgQue); old != nil {
+		old.Stop()
+	}
+	Infof("msgQue:%d resume session token:%s replay:%d", msgQue.ID(), token, replay)
+	return session, nil
+}
+
+func (r *SessionManager) Get(token string) *MsgSession {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.sessions[token]
+}
+
+func (r *SessionManager) Count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.sessions)
+}
+
+func (r *SessionManager) remove(session *MsgSession) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.sessions[session.token] != session {
+		return false
+	}
+	delete(r.sessions, session.token)
+	return true
+}
+
+func (r *SessionManager) bufSize() int {
+	if r.BufSize <= 0 {
+		return DefSessionBufSize
+	}
+	return r.BufSize
+}
+
+func (r *SessionManager) keepAlive() int {
+	if r.KeepAlive <= 0 {
+		return DefSessionKeepAlive
+	}
+	return r.KeepAlive
+}
+
+func NewSessionManager(bufSize, keepAlive int) *SessionManager {
+	return &SessionManager{
+		BufSize:   bufSize,
+		KeepAlive: keepAlive,
+		sessions:  map[string]*MsgSession{},
+	}
+}
+
+// SessionSeq returns the sequence and original data of a message sent by MsgSession, used by clients
+func SessionSeq(msg *Message) (seq uint32, data []byte, ok bool) {
+	if msg == nil || msg.Head == nil || msg.Head.Flags&FlagNeedAck == 0 || len(msg.Data) < SessionSeqSize {
+		return 0, nil, false
+	}
+	return binary.LittleEndian.Uint32(msg.Data), msg.Data[SessionSeqSize:], true
+}
+
+// NewSessionAckMsg acknowledges all session messages up to seq
+func NewSessionAckMsg(seq uint32) *Message {
+	data := make([]byte, SessionSeqSize)
+	binary.LittleEndian.PutUint32(data, seq)
+	return &Message{Head: &MessageHead{Len: SessionSeqSize, Flags: FlagAck}, Data: data}
+}
+
+func newSessionToken() string {
+	data := make([]byte, 16)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
+
+const SessionSeqSize = 4
+
+var DefSessionBufSize = 256
+var DefSessionKeepAlive = 60
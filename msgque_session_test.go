@@ -0,0 +1,109 @@
+package sugar
+
+import (
+	"sync"
+	"testing"
+)
+
+type captureMsgQue struct {
+	IMsgQue
+	sent   []*Message
+	drop   bool   // Send fails like a full queue with SlowDrop
+	onSend func() // invoked once after the next Send
+	lock   sync.Mutex
+}
+
+func (r *captureMsgQue) Send(m *Message) bool {
+	r.lock.Lock()
+	if r.drop {
+		r.lock.Unlock()
+		return false
+	}
+	r.sent = append(r.sent, m)
+	fn := r.onSend
+	r.onSend = nil
+	r.lock.Unlock()
+	if fn != nil {
+		fn()
+	}
+	return true
+}
+
+func newCaptureMsgQue() *captureMsgQue {
+	return &captureMsgQue{IMsgQue: newTestMsgQue()}
+}
+
+func Test_MsgSessionResume(t *testing.T) {
+	manager := NewSessionManager(0, 0)
+	first := newCaptureMsgQue()
+	session := manager.Create(first, 1001)
+	for i := 0; i < 3; i++ {
+		session.Send(NewMsg(1, 2, uint16(100+i), 0, []byte{byte(i)}))
+	}
+	seq, data, ok := SessionSeq(first.sent[2])
+	if !ok || seq != 3 || data[0] != 2 || first.sent[2].Head.Index != 102 {
+		t.Fatalf("session message should keep index, seq:%d index:%d", seq, first.sent[2].Head.Index)
+	}
+
+	session.Ack(1)
+	second := newCaptureMsgQue()
+	if _, err := manager.Resume(second, session.Token(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if len(second.sent) != 1 || second.sent[0].Head.Flags&FlagReSend == 0 {
+		t.Fatalf("want 1 replayed message got %d", len(second.sent))
+	}
+	if seq, _, _ := SessionSeq(second.sent[0]); seq != 3 || !first.IsStop() || session.GetMsgQue() != second {
+		t.Fatalf("replayed seq %d", seq)
+	}
+
+	// messages dropped by the full buffer cannot be replayed
+	manager.BufSize = 1
+	session.Send(NewMsg(1, 2, 0, 0, nil))
+	session.Send(NewMsg(1, 2, 0, 0, nil))
+	if _, err := manager.Resume(newCaptureMsgQue(), session.Token(), 3); err != ErrSessionLost {
+		t.Fatalf("want lost got %v", err)
+	}
+	if manager.Get(session.Token()) != nil {
+		t.Fatal("lost session should be removed")
+	}
+	if ack := NewSessionAckMsg(7); ack.Head.Flags&FlagAck == 0 || len(ack.Data) != SessionSeqSize {
+		t.Fatal("bad ack message")
+	}
+}
+
+func Test_MsgSessionResumeReplay(t *testing.T) {
+	manager := NewSessionManager(0, 0)
+	first := newCaptureMsgQue()
+	session := manager.Create(first, 1001)
+	session.Send(NewMsg(1, 2, 0, 0, nil))
+	session.Send(NewMsg(1, 2, 0, 0, nil))
+
+	// a replay not fully sent fails the resume but keeps the session
+	dropped := newCaptureMsgQue()
+	dropped.drop = true
+	if _, err := manager.Resume(dropped, session.Token(), 0); err != ErrSessionLost {
+		t.Fatalf("want lost got %v", err)
+	}
+	if manager.Get(session.Token()) != session || session.GetMsgQue() != first {
+		t.Fatal("failed resume should keep the session")
+	}
+
+	// the session is usable while replaying, messages sent meanwhile follow the replay
+	second := newCaptureMsgQue()
+	second.onSend = func() {
+		session.GetUser()
+		session.Send(NewMsg(1, 2, 0, 0, nil))
+	}
+	if _, err := manager.Resume(second, session.Token(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(second.sent) != 3 || session.GetMsgQue() != second {
+		t.Fatalf("want 3 replayed messages got %d", len(second.sent))
+	}
+	for i, v := range second.sent {
+		if seq, _, _ := SessionSeq(v); seq != uint32(i+1) {
+			t.Fatalf("replayed seq %d at %d", seq, i)
+		}
+	}
+}
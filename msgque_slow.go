@@ -0,0 +1,136 @@
+package sugar
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// MsgQueWriteStat is the outbound lag of a connection
+type MsgQueWriteStat struct {
+	QueuedMsgs  int           // messages waiting to be written
+	QueuedBytes int           // bytes waiting to be written
+	LastWrite   int64         // NowTick of last successful write, 0 means never written
+	Stall       time.Duration // time since queue became non empty or last successful write
+	Slow        bool          // reported as slow consumer and queue not drained yet
+	SlowCount   int           // times reported as slow consumer
+}
+
+// ISlowConsumerHandler can be implemented by IMsgHandler to be notified of slow consumers,
+// OnSlowConsumer runs in its own goroutine, the connection is stopped afterwards if ConnOptions.SlowKick is set
+type ISlowConsumerHandler interface {
+	OnSlowConsumer(msgQue IMsgQue, stat MsgQueWriteStat)
+}
+
+type writeStat struct {
+	queuedMsgs  int32
+	queuedBytes int64
+	lastWrite   int64
+	stallSince  int64
+	slow        int32
+	slowCount   int32
+}
+
+func (r *ConnOptions) slowDetect() bool {
+	return r != nil && (r.SlowQueueBytes > 0 || r.SlowWriteStall > 0)
+}
+
+func (r *msgQue) msgSize(m *Message) int {
+	if r.msgTyp == MsgTypeCmd || m.Head == nil {
+		return len(m.Data)
+	}
+	return MsgHeadSize + int(m.Head.Len)
+}
+
+// queued returns the queued bytes, checkQueued should be invoked with it after the message is queued
+func (r *msgQue) queued(size int) int64 {
+	if atomic.AddInt32(&r.wstat.queuedMsgs, 1) == 1 {
+		atomic.StoreInt64(&r.wstat.stallSince, GetNowTick())
+	}
+	return atomic.AddInt64(&r.wstat.queuedBytes, int64(size))
+}
+
+func (r *msgQue) checkQueued(bytes int64) {
+	if r.opts.slowDetect() {
+		app := r.app
+		app.slowCheckOnce.Do(func() {
+			app.SetTimeout(1000, app.checkSlowConsumers)
+		})
+		if r.opts.SlowQueueBytes > 0 && bytes > int64(r.opts.SlowQueueBytes) {
+			r.reportSlow()
+		}
+	}
+}
+
+// unqueue reverts queued when message is not sent
+func (r *msgQue) unqueue(size int) {
+	atomic.AddInt32(&r.wstat.queuedMsgs, -1)
+	atomic.AddInt64(&r.wstat.queuedBytes, -int64(size))
+}
+
+// written is invoked after n bytes written successfully, done means a whole message is written
+func (r *msgQue) written(n int, done bool) {
+	atomic.StoreInt64(&r.wstat.lastWrite, GetNowTick())
+	atomic.StoreInt64(&r.wstat.stallSince, GetNowTick())
+	atomic.AddInt64(&r.wstat.queuedBytes, -int64(n))
+	if done && atomic.AddInt32(&r.wstat.queuedMsgs, -1) <= 0 {
+		atomic.StoreInt32(&r.wstat.slow, 0)
+	}
+}
+
+func (r *msgQue) GetWriteStat() MsgQueWriteStat {
+	stat := MsgQueWriteStat{
+		QueuedMsgs:  int(atomic.LoadInt32(&r.wstat.queuedMsgs)),
+		QueuedBytes: int(atomic.LoadInt64(&r.wstat.queuedBytes)),
+		LastWrite:   atomic.LoadInt64(&r.wstat.lastWrite),
+		Slow:        atomic.LoadInt32(&r.wstat.slow) == 1,
+		SlowCount:   int(atomic.LoadInt32(&r.wstat.slowCount)),
+	}
+	if stat.QueuedMsgs > 0 {
+		stat.Stall = time.Duration(GetNowTick()-atomic.LoadInt64(&r.wstat.stallSince)) * time.Millisecond
+	}
+	return stat
+}
+
+func (r *msgQue) checkSlow() {
+	if !r.opts.slowDetect() || r.opts.SlowWriteStall <= 0 || atomic.LoadInt32(&r.wstat.queuedMsgs) <= 0 {
+		return
+	}
+	if time.Duration(GetNowTick()-atomic.LoadInt64(&r.wstat.stallSince))*time.Millisecond >= r.opts.SlowWriteStall {
+		r.reportSlow()
+	}
+}
+
+// reportSlow notifies handler once until the queue is drained
+func (r *msgQue) reportSlow() {
+	if !atomic.CompareAndSwapInt32(&r.wstat.slow, 0, 1) {
+		return
+	}
+	atomic.AddInt32(&r.wstat.slowCount, 1)
+	atomic.AddInt32(&r.app.stat.SlowConsumerCount, 1)
+	msgQue := r.app.getMsgQue(r.id)
+	if msgQue == nil {
+		return
+	}
+	stat := r.GetWriteStat()
+	Warnf("msgQue:%d slow consumer queued msgs:%d bytes:%d stall:%v", r.id, stat.QueuedMsgs, stat.QueuedBytes, stat.Stall)
+	h, notify := r.handler.(ISlowConsumerHandler)
+	if !notify && !r.opts.SlowKick {
+		return
+	}
+	// never on the sender goroutine, which may hold locks of the caller
+	r.app.Go(func() {
+		if notify {
+			h.OnSlowConsumer(msgQue, stat)
+		}
+		if r.opts.SlowKick {
+			msgQue.Stop()
+		}
+	})
+}
+
+func (r *App) checkSlowConsumers(...interface{}) int {
+	for _, v := range r.MsgQues() {
+		v.checkSlow()
+	}
+	return 1000
+}
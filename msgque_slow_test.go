@@ -0,0 +1,89 @@
+package sugar
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type slowTestHandler struct {
+	DefMsgHandler
+	slow chan MsgQueWriteStat
+}
+
+func (r *slowTestHandler) OnSlowConsumer(msgQue IMsgQue, stat MsgQueWriteStat) {
+	r.slow <- stat
+}
+
+func Test_SlowConsumer(t *testing.T) {
+	c, _ := net.Pipe()
+	opts := NewConnOptions()
+	opts.SlowQueueBytes = 1024
+	opts.SlowKick = true
+	handler := &slowTestHandler{slow: make(chan MsgQueWriteStat, 1)}
+	msgQue := newTCPAccept(DefApp, c, MsgTypeMsg, handler, nil, opts)
+	msgQue.available = true
+	Go(msgQue.write)
+	// the 4th message exceeds SlowQueueBytes, no more sends race with the kick
+	for i := 0; i < 4; i++ {
+		msgQue.Send(NewMsg(1, 1, 0, 0, make([]byte, 256)))
+	}
+	select {
+	case stat := <-handler.slow:
+		if stat.QueuedBytes <= 1024 {
+			t.Fatalf("unexpected stat %+v", stat)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer not reported")
+	}
+	for !msgQue.IsStop() {
+		Sleep(1)
+	}
+}
+
+func Test_SlowConsumerBlocking(t *testing.T) {
+	c, _ := net.Pipe()
+	opts := NewConnOptions()
+	opts.SlowQueueBytes = 1024
+	handler := &slowTestHandler{slow: make(chan MsgQueWriteStat)}
+	msgQue := newTCPAccept(DefApp, c, MsgTypeMsg, handler, nil, opts)
+	msgQue.available = true
+	defer msgQue.Stop()
+
+	// the handler blocks until read, senders must not wait for it nor lose messages
+	done := make(chan bool, 1)
+	go func() {
+		ok := true
+		for i := 0; i < 10; i++ {
+			ok = msgQue.Send(NewMsg(1, 1, 0, 0, make([]byte, 256))) && ok
+		}
+		done <- ok
+	}()
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatal("messages dropped without SlowDrop")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("sender blocked by OnSlowConsumer")
+	}
+	select {
+	case <-handler.slow:
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer not reported")
+	}
+
+	drop := newTCPAccept(DefApp, c, MsgTypeMsg, &DefMsgHandler{}, nil, opts)
+	drop.available = true
+	defer drop.Stop()
+	opts.SlowDrop = true
+	sent := 0
+	for i := 0; i < 100; i++ {
+		if drop.Send(NewMsg(1, 1, 0, 0, nil)) {
+			sent++
+		}
+	}
+	if sent != cap(drop.writeCh) || drop.GetWriteStat().SlowCount != 1 {
+		t.Fatalf("sent %d of %d slow count %d", sent, cap(drop.writeCh), drop.GetWriteStat().SlowCount)
+	}
+}
@@ -0,0 +1,207 @@
+//go:build linux
+// +build linux
+
+package sugar
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+var reusePortControl = func(network, address string, c syscall.RawConn) error {
+	var err error
+	c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	return err
+}
+
+type mmsghdr struct {
+	hdr syscall.Msghdr
+	len uint32
+}
+
+type udpReadBatch struct {
+	bufs  [][]byte
+	sizes []int
+	addrs []*net.UDPAddr
+	msgs  []mmsghdr
+	iovs  []syscall.Iovec
+	names []syscall.RawSockaddrAny
+}
+
+func newUDPReadBatch(size int) *udpReadBatch {
+	if size < 1 {
+		size = 1
+	}
+	r := &udpReadBatch{
+		bufs:  make([][]byte, size),
+		sizes: make([]int, size),
+		addrs: make([]*net.UDPAddr, size),
+	}
+	for i := range r.bufs {
+		r.bufs[i] = make([]byte, 1<<16)
+	}
+	if size > 1 {
+		r.msgs = make([]mmsghdr, size)
+		r.iovs = make([]syscall.Iovec, size)
+		r.names = make([]syscall.RawSockaddrAny, size)
+		for i := range r.msgs {
+			r.iovs[i].Base = &r.bufs[i][0]
+			r.iovs[i].SetLen(len(r.bufs[i]))
+			r.msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&r.names[i]))
+			r.msgs[i].hdr.Iov = &r.iovs[i]
+			r.msgs[i].hdr.Iovlen = 1
+		}
+	}
+	return r
+}
+
+// read receives up to len(bufs) datagrams with one recvmmsg
+func (r *udpReadBatch) read(conn *net.UDPConn) (int, error) {
+	if r.msgs == nil {
+		n, addr, err := conn.ReadFromUDP(r.bufs[0])
+		if err != nil {
+			return 0, err
+		}
+		r.sizes[0], r.addrs[0] = n, addr
+		return 1, nil
+	}
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	cnt := 0
+	var serr error
+	err = raw.Read(func(fd uintptr) bool {
+		for i := range r.msgs {
+			r.msgs[i].hdr.Namelen = syscall.SizeofSockaddrAny
+			r.msgs[i].len = 0
+		}
+		n, _, e := syscall.Syscall6(syscall.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&r.msgs[0])), uintptr(len(r.msgs)), 0, 0, 0)
+		if e == syscall.EAGAIN {
+			return false
+		}
+		if e != 0 {
+			serr = e
+		} else {
+			cnt = int(n)
+		}
+		return true
+	})
+	if err == nil {
+		err = serr
+	}
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < cnt; i++ {
+		r.sizes[i] = int(r.msgs[i].len)
+		r.addrs[i] = sockaddrToUDP(&r.names[i])
+	}
+	return cnt, nil
+}
+
+func sockaddrToUDP(rsa *syscall.RawSockaddrAny) *net.UDPAddr {
+	switch rsa.Addr.Family {
+	case syscall.AF_INET:
+		sa := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+		return &net.UDPAddr{IP: net.IPv4(sa.Addr[0], sa.Addr[1], sa.Addr[2], sa.Addr[3]), Port: int(port[0])<<8 + int(port[1])}
+	case syscall.AF_INET6:
+		sa := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, sa.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: int(port[0])<<8 + int(port[1])}
+	}
+	return &net.UDPAddr{}
+}
+
+func udpToSockaddr(addr *net.UDPAddr, family uint16, rsa *syscall.RawSockaddrAny) uint32 {
+	if family == syscall.AF_INET {
+		sa := (*syscall.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		sa.Family = syscall.AF_INET
+		port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+		port[0], port[1] = byte(addr.Port>>8), byte(addr.Port)
+		copy(sa.Addr[:], addr.IP.To4())
+		return syscall.SizeofSockaddrInet4
+	}
+	sa := (*syscall.RawSockaddrInet6)(unsafe.Pointer(rsa))
+	sa.Family = syscall.AF_INET6
+	port := (*[2]byte)(unsafe.Pointer(&sa.Port))
+	port[0], port[1] = byte(addr.Port>>8), byte(addr.Port)
+	copy(sa.Addr[:], addr.IP.To16())
+	return syscall.SizeofSockaddrInet6
+}
+
+// udpFamily returns the address family of conn, addresses passed to sendmmsg must match it
+func udpFamily(conn *net.UDPConn) uint16 {
+	family := uint16(syscall.AF_INET6)
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return family
+	}
+	raw.Control(func(fd uintptr) {
+		if sa, err := syscall.Getsockname(int(fd)); err == nil {
+			if _, ok := sa.(*syscall.SockaddrInet4); ok {
+				family = syscall.AF_INET
+			}
+		}
+	})
+	return family
+}
+
+// sendUDPBatch sends packets with sendmmsg and returns the number of packets failed
+func sendUDPBatch(conn *net.UDPConn, family uint16, packets []udpPacket) int {
+	if len(packets) == 1 {
+		if _, err := conn.WriteToUDP(packets[0].data, packets[0].addr); err != nil {
+			return 1
+		}
+		return 0
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return len(packets)
+	}
+
+	msgs := make([]mmsghdr, len(packets))
+	iovs := make([]syscall.Iovec, len(packets))
+	names := make([]syscall.RawSockaddrAny, len(packets))
+	for i, v := range packets {
+		if len(v.data) > 0 {
+			iovs[i].Base = &v.data[0]
+		}
+		iovs[i].SetLen(len(v.data))
+		msgs[i].hdr.Name = (*byte)(unsafe.Pointer(&names[i]))
+		msgs[i].hdr.Namelen = udpToSockaddr(v.addr, family, &names[i])
+		msgs[i].hdr.Iov = &iovs[i]
+		msgs[i].hdr.Iovlen = 1
+	}
+
+	sent, failed := 0, 0
+	err = raw.Write(func(fd uintptr) bool {
+		for sent < len(msgs) {
+			n, _, e := syscall.Syscall6(sysSendmmsg, fd, uintptr(unsafe.Pointer(&msgs[sent])), uintptr(len(msgs)-sent), 0, 0, 0)
+			if e == syscall.EAGAIN {
+				return false
+			}
+			if e == syscall.EINTR {
+				continue
+			}
+			if e != 0 {
+				sent++ // skip the packet failed
+				failed++
+				continue
+			}
+			sent += int(n)
+		}
+		return true
+	})
+	if err != nil {
+		failed += len(msgs) - sent
+	}
+	return failed
+}
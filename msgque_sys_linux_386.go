@@ -0,0 +1,7 @@
+package sugar
+
+// not exported by package syscall on 386
+const (
+	sysSendmmsg = 345
+	soReusePort = 0xf
+)
@@ -0,0 +1,7 @@
+package sugar
+
+// not exported by package syscall on amd64
+const (
+	sysSendmmsg = 307
+	soReusePort = 0xf
+)
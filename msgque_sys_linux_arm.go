@@ -0,0 +1,8 @@
+package sugar
+
+import "syscall"
+
+const (
+	sysSendmmsg = syscall.SYS_SENDMMSG
+	soReusePort = 0xf // not exported by package syscall on arm
+)
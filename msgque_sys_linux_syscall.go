@@ -0,0 +1,11 @@
+//go:build linux && !amd64 && !386 && !arm
+// +build linux,!amd64,!386,!arm
+
+package sugar
+
+import "syscall"
+
+const (
+	sysSendmmsg = syscall.SYS_SENDMMSG
+	soReusePort = syscall.SO_REUSEPORT
+)
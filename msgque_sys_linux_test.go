@@ -0,0 +1,97 @@
+package sugar
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_UDPSockaddr(t *testing.T) {
+	for _, v := range []struct {
+		addr   string
+		family uint16
+	}{{"10.0.0.1:4321", syscall.AF_INET}, {"[fe80::1]:65535", syscall.AF_INET6}, {"10.0.0.1:80", syscall.AF_INET6}} {
+		addr, _ := net.ResolveUDPAddr("udp", v.addr)
+		var rsa syscall.RawSockaddrAny
+		udpToSockaddr(addr, v.family, &rsa)
+		if back := sockaddrToUDP(&rsa); !back.IP.Equal(addr.IP) || back.Port != addr.Port {
+			t.Fatalf("%v back to %v", addr, back)
+		}
+	}
+}
+
+func Test_UDPBatch(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	family := udpFamily(client)
+	if family != syscall.AF_INET {
+		t.Fatalf("family %v want AF_INET", family)
+	}
+	to := server.LocalAddr().(*net.UDPAddr)
+	packets := []udpPacket{{[]byte("a"), to}, {[]byte("bb"), to}, {[]byte("ccc"), to}}
+	if failed := sendUDPBatch(client, family, packets); failed != 0 {
+		t.Fatalf("%d packets failed", failed)
+	}
+
+	batch := newUDPReadBatch(8)
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var got []string
+	for len(got) < len(packets) {
+		cnt, err := batch.read(server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < cnt; i++ {
+			if batch.addrs[i].Port != client.LocalAddr().(*net.UDPAddr).Port {
+				t.Fatalf("bad source %v", batch.addrs[i])
+			}
+			got = append(got, string(batch.bufs[i][:batch.sizes[i]]))
+		}
+	}
+	for i, v := range packets {
+		if got[i] != string(v.data) {
+			t.Fatalf("packet %d is %q want %q", i, got[i], v.data)
+		}
+	}
+}
+
+func Test_UDPSendDropCount(t *testing.T) {
+	app := NewApp()
+	msgQue := &udpMsgQue{msgQue: msgQue{app: app}, addr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}}
+	msgQue.batcher = &udpBatcher{app: app, ch: make(chan udpPacket, 1)}
+	if err := msgQue.writeTo([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := msgQue.writeTo([]byte("b")); err != ErrUDPSendDropped {
+		t.Fatalf("want dropped got %v", err)
+	}
+	if cnt := atomic.LoadInt32(&app.GetStat().UDPDropCount); cnt != 1 {
+		t.Fatalf("drop count %d want 1", cnt)
+	}
+}
+
+func Test_ReusePort(t *testing.T) {
+	lc := net.ListenConfig{Control: reusePortControl}
+	a, err := lc.ListenPacket(context.Background(), "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := lc.ListenPacket(context.Background(), "udp", a.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("second listener on the same port: %v", err)
+	}
+	b.Close()
+}
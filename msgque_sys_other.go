@@ -0,0 +1,49 @@
+//go:build !linux
+// +build !linux
+
+package sugar
+
+import (
+	"net"
+	"syscall"
+)
+
+// SO_REUSEPORT load balancing is only supported on linux, ReusePortCnt is ignored
+var reusePortControl func(network, address string, c syscall.RawConn) error
+
+type udpReadBatch struct {
+	bufs  [][]byte
+	sizes []int
+	addrs []*net.UDPAddr
+}
+
+func newUDPReadBatch(size int) *udpReadBatch {
+	return &udpReadBatch{
+		bufs:  [][]byte{make([]byte, 1<<16)},
+		sizes: make([]int, 1),
+		addrs: make([]*net.UDPAddr, 1),
+	}
+}
+
+func (r *udpReadBatch) read(conn *net.UDPConn) (int, error) {
+	n, addr, err := conn.ReadFromUDP(r.bufs[0])
+	if err != nil {
+		return 0, err
+	}
+	r.sizes[0], r.addrs[0] = n, addr
+	return 1, nil
+}
+
+func udpFamily(conn *net.UDPConn) uint16 {
+	return 0
+}
+
+func sendUDPBatch(conn *net.UDPConn, family uint16, packets []udpPacket) int {
+	failed := 0
+	for _, v := range packets {
+		if _, err := conn.WriteToUDP(v.data, v.addr); err != nil {
+			failed++
+		}
+	}
+	return failed
+}
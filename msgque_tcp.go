@@ -17,14 +17,27 @@ type tcpMsgQue struct {
 	address    string
 	wait       sync.WaitGroup
 	connecting int32
+	poll       *pollConn
+	mux        *MuxServer
 }
 
 func (r *tcpMsgQue) GetNetType() NetType {
 	return NetTypeTCP
 }
 func (r *tcpMsgQue) Stop() {
+	if r.IsDraining() {
+		return
+	}
+	if timeout := r.drainTimeout(); timeout > 0 {
+		r.Drain(timeout)
+		return
+	}
+	r.stopNow()
+}
+
+func (r *tcpMsgQue) stopNow() {
 	if atomic.CompareAndSwapInt32(&r.stop, 0, 1) {
-		Go(func() {
+		r.app.Go(func() {
 			if r.init {
 				r.handler.OnDelMsgQue(r)
 				if r.connecting == 1 {
@@ -35,9 +48,13 @@ func (r *tcpMsgQue) Stop() {
 			r.available = false
 			if r.listener != nil {
 				if tcp, ok := r.listener.(*net.TCPListener); ok {
+					removeUpgradeListener(tcp)
 					tcp.Close()
 				}
 			}
+			if r.poll != nil {
+				r.poll.close()
+			}
 
 			r.BaseStop()
 		})
@@ -45,12 +62,12 @@ func (r *tcpMsgQue) Stop() {
 }
 
 func (r *tcpMsgQue) IsStop() bool {
-	if r.stop == 0 {
-		if IsStop() {
+	if atomic.LoadInt32(&r.stop) == 0 {
+		if r.app.IsStop() {
 			r.Stop()
 		}
 	}
-	return r.stop == 1
+	return atomic.LoadInt32(&r.stop) == 1
 }
 
 func (r *tcpMsgQue) LocalAddr() string {
@@ -75,8 +92,8 @@ func (r *tcpMsgQue) readMsg() {
 	var head *MessageHead
 
 	for !r.IsStop() {
-		if r.timeout > 0 {
-			r.conn.SetReadDeadline(time.Now().Add(time.Duration(r.timeout) * time.Second))
+		if r.readTimeout > 0 {
+			r.conn.SetReadDeadline(time.Now().Add(r.readTimeout))
 		}
 		if head == nil {
 			_, err := io.ReadFull(r.conn, headData)
@@ -123,16 +140,13 @@ func (r *tcpMsgQue) writeMsg() {
 	writeCount := 0
 	for !r.IsStop() || m != nil {
 		if m == nil {
-			select {
-			case m = <-r.writeCh:
-				if m != nil {
-					head = m.Head.Bytes()
-				}
+			if m = r.nextMsg(); m != nil {
+				head = m.Head.Bytes()
 			}
 		}
 		if m != nil {
-			if r.timeout > 0 {
-				r.conn.SetWriteDeadline(time.Now().Add(time.Duration(r.timeout) * time.Second))
+			if r.writeTimeout > 0 {
+				r.conn.SetWriteDeadline(time.Now().Add(r.writeTimeout))
 			}
 			if writeCount < MsgHeadSize {
 				n, err := r.conn.Write(head[writeCount:])
@@ -142,15 +156,17 @@ func (r *tcpMsgQue) writeMsg() {
 					break
 				}
 				writeCount += n
+				r.written(n, writeCount == int(m.Head.Len)+MsgHeadSize)
 			}
 
-			if writeCount >= MsgHeadSize && m.Data != nil {
+			if writeCount >= MsgHeadSize && writeCount < int(m.Head.Len)+MsgHeadSize && m.Data != nil {
 				n, err := r.conn.Write(m.Data[writeCount-MsgHeadSize : int(m.Head.Len)])
 				if err != nil {
 					Errorf("msgQue write id:%v err:%v", r.id, err)
 					break
 				}
 				writeCount += n
+				r.written(n, writeCount == int(m.Head.Len)+MsgHeadSize)
 			}
 
 			if writeCount == int(m.Head.Len)+MsgHeadSize {
@@ -164,8 +180,8 @@ func (r *tcpMsgQue) writeMsg() {
 func (r *tcpMsgQue) readCmd() {
 	reader := bufio.NewReader(r.conn)
 	for !r.IsStop() {
-		if r.timeout > 0 {
-			r.conn.SetReadDeadline(time.Now().Add(time.Duration(r.timeout) * time.Second))
+		if r.readTimeout > 0 {
+			r.conn.SetReadDeadline(time.Now().Add(r.readTimeout))
 		}
 		data, err := reader.ReadBytes('\n')
 		if err != nil {
@@ -182,13 +198,11 @@ func (r *tcpMsgQue) writeCmd() {
 	writeCount := 0
 	for !r.IsStop() || m != nil {
 		if m == nil {
-			select {
-			case m = <-r.writeCh:
-			}
+			m = r.nextMsg()
 		}
 		if m != nil {
-			if r.timeout > 0 {
-				r.conn.SetWriteDeadline(time.Now().Add(time.Duration(r.timeout) * time.Second))
+			if r.writeTimeout > 0 {
+				r.conn.SetWriteDeadline(time.Now().Add(r.writeTimeout))
 			}
 			n, err := r.conn.Write(m.Data[writeCount:])
 			if err != nil {
@@ -196,6 +210,7 @@ func (r *tcpMsgQue) writeCmd() {
 				break
 			}
 			writeCount += n
+			r.written(n, writeCount == len(m.Data))
 			if writeCount == len(m.Data) {
 				writeCount = 0
 				m = nil
@@ -206,6 +221,7 @@ func (r *tcpMsgQue) writeCmd() {
 
 func (r *tcpMsgQue) read() {
 	defer func() {
+		atomic.StoreInt32(&r.readExit, 1)
 		r.wait.Done()
 		if err := recover(); err != nil {
 			Errorf("msgQue read panic id:%v err:%v", r.id, err.(error))
@@ -224,6 +240,7 @@ func (r *tcpMsgQue) read() {
 
 func (r *tcpMsgQue) write() {
 	defer func() {
+		atomic.StoreInt32(&r.writeExit, 1)
 		r.wait.Done()
 		if err := recover(); err != nil {
 			Errorf("msgQue write panic id:%v err:%v", r.id, err.(error))
@@ -247,51 +264,76 @@ func (r *tcpMsgQue) listen() {
 		if err != nil {
 			break
 		} else {
-			Go(func() {
-				msgQue := newTCPAccept(c, r.msgTyp, r.handler, r.parserFactory)
-				if r.handler.OnNewMsgQue(msgQue) {
-					msgQue.init = true
-					msgQue.available = true
-					Go(func() {
-						Infof("process read for msgQue:%d", msgQue.id)
-						msgQue.read()
-						Infof("process read end for msgQue:%d", msgQue.id)
-					})
-					Go(func() {
-						Infof("process write for msgQue:%d", msgQue.id)
-						msgQue.write()
-						Infof("process write end for msgQue:%d", msgQue.id)
-					})
-				} else {
-					msgQue.Stop()
-				}
+			key, ok := r.app.limiter.acquire(c.RemoteAddr())
+			if !ok {
+				Debugf("reject connection from addr:%s", c.RemoteAddr().String())
+				c.Close()
+				continue
+			}
+			if r.mux != nil {
+				r.app.Go(func() {
+					r.mux.serve(r.app, c, key, r.opts, r.reactor)
+				})
+				continue
+			}
+			r.app.Go(func() {
+				startTCPAccept(r.app, c, key, r.msgTyp, r.handler, r.parserFactory, r.opts, r.reactor)
 			})
 		}
 	}
 
+	if r.mux != nil {
+		r.mux.close()
+	}
 	r.Stop()
 }
 
+func startTCPAccept(app *App, c net.Conn, key string, msgTyp MsgType, handler IMsgHandler, parser *Parser, opts *ConnOptions, reactor bool) {
+	msgQue := newTCPAccept(app, c, msgTyp, handler, parser, opts)
+	msgQue.limited = true
+	msgQue.limitKey = key
+	if handler.OnNewMsgQue(msgQue) {
+		msgQue.init = true
+		if reactor && app.startPollConn(msgQue) {
+			return
+		}
+		msgQue.available = true
+		app.Go(func() {
+			Infof("process read for msgQue:%d", msgQue.id)
+			msgQue.read()
+			Infof("process read end for msgQue:%d", msgQue.id)
+		})
+		app.Go(func() {
+			Infof("process write for msgQue:%d", msgQue.id)
+			msgQue.write()
+			Infof("process write end for msgQue:%d", msgQue.id)
+		})
+	} else {
+		msgQue.Stop()
+	}
+}
+
 func (r *tcpMsgQue) connect() {
 	Infof("connect to addr:%s msgQue:%d", r.address, r.id)
-	c, err := net.DialTimeout(r.network, r.address, time.Second)
+	c, err := net.DialTimeout(r.network, r.address, r.opts.dialTimeout())
 	if err != nil {
 		Infof("connect to addr:%s failed msgQue:%d", r.address, r.id)
 		r.handler.OnConnectComplete(r, false)
 		atomic.CompareAndSwapInt32(&r.connecting, 1, 0)
 		r.Stop()
 	} else {
+		r.opts.applyTCP(c)
 		r.conn = c
 		r.available = true
 		Infof("connect to addr:%s ok msgQue:%d", r.address, r.id)
 		if r.handler.OnConnectComplete(r, true) {
 			atomic.CompareAndSwapInt32(&r.connecting, 1, 0)
-			Go(func() {
+			r.app.Go(func() {
 				Infof("process read for msgQue:%d", r.id)
 				r.read()
 				Infof("process read end for msgQue:%d", r.id)
 			})
-			Go(func() {
+			r.app.Go(func() {
 				Infof("process write for msgQue:%d", r.id)
 				r.write()
 				Infof("process write end for msgQue:%d", r.id)
@@ -304,11 +346,11 @@ func (r *tcpMsgQue) connect() {
 }
 
 func (r *tcpMsgQue) Reconnect(t int) {
-	if IsStop() {
+	if r.app.IsStop() {
 		return
 	}
 	if r.conn != nil {
-		if r.stop == 0 {
+		if atomic.LoadInt32(&r.stop) == 0 {
 			return
 		}
 	}
@@ -323,7 +365,7 @@ func (r *tcpMsgQue) Reconnect(t int) {
 		}
 	}
 	r.init = true
-	Go(func() {
+	r.app.Go(func() {
 		if r.conn != nil {
 			r.conn.Close()
 			if len(r.writeCh) == 0 {
@@ -333,7 +375,7 @@ func (r *tcpMsgQue) Reconnect(t int) {
 		}
 		r.stop = 0
 		if t > 0 {
-			SetTimeout(t*1000, func(arg ...interface{}) int {
+			r.app.SetTimeout(t*1000, func(arg ...interface{}) int {
 				r.connect()
 				return 0
 			})
@@ -344,16 +386,21 @@ func (r *tcpMsgQue) Reconnect(t int) {
 	})
 }
 
-func newTCPConn(network, addr string, conn net.Conn, msgTyp MsgType, handler IMsgHandler, parser *Parser, user interface{}) *tcpMsgQue {
+func newTCPConn(app *App, network, addr string, conn net.Conn, msgTyp MsgType, handler IMsgHandler, parser *Parser, user interface{}, opts *ConnOptions) *tcpMsgQue {
 	msgQue := tcpMsgQue{
 		msgQue: msgQue{
 			id:            atomic.AddUint32(&msgQueID, 1),
+			app:           app,
 			writeCh:       make(chan *Message, 64),
+			highCh:        make(chan *Message, MsgPriorityLaneSize),
+			lowCh:         make(chan *Message, MsgPriorityLaneSize),
 			msgTyp:        msgTyp,
 			handler:       handler,
-			timeout:       DefMsgQueTimeout,
+			readTimeout:   opts.readTimeout(),
+			writeTimeout:  opts.writeTimeout(),
 			connTyp:       ConnTypeConn,
 			parserFactory: parser,
+			opts:          opts,
 			user:          user,
 		},
 		conn:    conn,
@@ -363,51 +410,54 @@ func newTCPConn(network, addr string, conn net.Conn, msgTyp MsgType, handler IMs
 	if parser != nil {
 		msgQue.parser = parser.Get()
 	}
-	msgQueMapSync.Lock()
-	msgQueMap[msgQue.id] = &msgQue
-	msgQueMapSync.Unlock()
+	app.addMsgQue(&msgQue)
 	Infof("new msgQue id:%d connect to addr:%s:%s", msgQue.id, network, addr)
 	return &msgQue
 }
 
-func newTCPAccept(conn net.Conn, msgtyp MsgType, handler IMsgHandler, parser *Parser) *tcpMsgQue {
+func newTCPAccept(app *App, conn net.Conn, msgtyp MsgType, handler IMsgHandler, parser *Parser, opts *ConnOptions) *tcpMsgQue {
 	msgQue := tcpMsgQue{
 		msgQue: msgQue{
 			id:            atomic.AddUint32(&msgQueID, 1),
+			app:           app,
 			writeCh:       make(chan *Message, 64),
+			highCh:        make(chan *Message, MsgPriorityLaneSize),
+			lowCh:         make(chan *Message, MsgPriorityLaneSize),
 			msgTyp:        msgtyp,
 			handler:       handler,
-			timeout:       DefMsgQueTimeout,
+			readTimeout:   opts.readTimeout(),
+			writeTimeout:  opts.writeTimeout(),
 			connTyp:       ConnTypeAccept,
 			parserFactory: parser,
+			opts:          opts,
 		},
 		conn: conn,
 	}
+	opts.applyTCP(conn)
 	if parser != nil {
 		msgQue.parser = parser.Get()
 	}
-	msgQueMapSync.Lock()
-	msgQueMap[msgQue.id] = &msgQue
-	msgQueMapSync.Unlock()
+	app.addMsgQue(&msgQue)
 	Infof("new msgQue id:%d from addr:%s", msgQue.id, conn.RemoteAddr().String())
 	return &msgQue
 }
 
-func newTCPListen(listener net.Listener, msgtyp MsgType, handler IMsgHandler, parser *Parser, addr string) *tcpMsgQue {
+func newTCPListen(app *App, listener net.Listener, msgtyp MsgType, handler IMsgHandler, parser *Parser, addr string, opts *ConnOptions) *tcpMsgQue {
 	msgQue := tcpMsgQue{
 		msgQue: msgQue{
 			id:            atomic.AddUint32(&msgQueID, 1),
+			app:           app,
 			msgTyp:        msgtyp,
 			handler:       handler,
 			parserFactory: parser,
+			opts:          opts,
 			connTyp:       ConnTypeListen,
+			reactor:       ReactorMode,
 		},
 		listener: listener,
 	}
 
-	msgQueMapSync.Lock()
-	msgQueMap[msgQue.id] = &msgQue
-	msgQueMapSync.Unlock()
+	app.addMsgQue(&msgQue)
 	Infof("new tcp listen id:%d addr:%s", msgQue.id, addr)
 	return &msgQue
 }
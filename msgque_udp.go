@@ -1,6 +1,7 @@
 package sugar
 
 import (
+	"encoding/binary"
 	"net"
 	"sync"
 	"sync/atomic"
@@ -12,7 +13,13 @@ type udpMsgQue struct {
 	conn     *net.UDPConn
 	readCh   chan []byte // channel
 	addr     *net.UDPAddr
+	addrLock sync.Mutex
+	connID   uint64 // only used when UDPConnID enabled
 	lastTick int64
+	pending  int32 // accepted but the peer not proved to own the address yet
+	recvLock sync.Mutex
+	batcher  *udpBatcher // only set when UDPBatchSize > 1
+	goCnt    int         // number of listen goroutines
 	sync.Mutex
 }
 
@@ -21,21 +28,39 @@ func (r *udpMsgQue) GetNetType() NetType {
 }
 
 func (r *udpMsgQue) Stop() {
+	if r.IsDraining() {
+		return
+	}
+	if timeout := r.drainTimeout(); timeout > 0 {
+		r.Drain(timeout)
+		return
+	}
+	r.stopNow()
+}
+
+func (r *udpMsgQue) stopNow() {
 	if atomic.CompareAndSwapInt32(&r.stop, 0, 1) {
-		Go(func() {
+		r.app.Go(func() {
 			if r.init {
 				r.handler.OnDelMsgQue(r)
 			}
 			r.available = false
+			r.donePending()
+			if r.connTyp == ConnTypeListen {
+				removeUpgradeListener(r.conn)
+			}
 			if r.readCh != nil {
 				close(r.readCh)
 			}
 
-			udpMapLock.Lock()
-			delete(udpMap, r.addr.String())
-			udpMapLock.Unlock()
+			r.app.udpMapLock.Lock()
+			delete(r.app.udpMap, r.mapKey())
+			if addr := r.getAddr().String(); r.app.udpAddrMap[addr] == r {
+				delete(r.app.udpAddrMap, addr)
+			}
+			r.app.udpMapLock.Unlock()
 
-			if IsStop() && len(udpMap) == 0 && r.conn != nil {
+			if r.app.IsStop() && len(r.app.udpMap) == 0 && r.conn != nil {
 				r.conn.Close()
 			}
 			r.BaseStop()
@@ -43,13 +68,19 @@ func (r *udpMsgQue) Stop() {
 	}
 }
 
+func (r *udpMsgQue) donePending() {
+	if atomic.CompareAndSwapInt32(&r.pending, 1, 0) {
+		atomic.AddInt32(&r.app.udpPendingCount, -1)
+	}
+}
+
 func (r *udpMsgQue) IsStop() bool {
-	if r.stop == 0 {
-		if IsStop() {
+	if atomic.LoadInt32(&r.stop) == 0 {
+		if r.app.IsStop() {
 			r.Stop()
 		}
 	}
-	return r.stop == 1
+	return atomic.LoadInt32(&r.stop) == 1
 }
 
 func (r *udpMsgQue) LocalAddr() string {
@@ -60,12 +91,31 @@ func (r *udpMsgQue) LocalAddr() string {
 }
 
 func (r *udpMsgQue) RemoteAddr() string {
-	if r.addr != nil {
-		return r.addr.String()
+	if addr := r.getAddr(); addr != nil {
+		return addr.String()
 	}
 	return ""
 }
 
+func (r *udpMsgQue) getAddr() *net.UDPAddr {
+	r.addrLock.Lock()
+	defer r.addrLock.Unlock()
+	return r.addr
+}
+
+func (r *udpMsgQue) setAddr(addr *net.UDPAddr) {
+	r.addrLock.Lock()
+	r.addr = addr
+	r.addrLock.Unlock()
+}
+
+func (r *udpMsgQue) mapKey() string {
+	if r.connID != 0 {
+		return udpConnIDKey(r.connID)
+	}
+	return r.getAddr().String()
+}
+
 func (r *udpMsgQue) read() {
 	defer func() {
 		if err := recover(); err != nil {
@@ -82,36 +132,73 @@ func (r *udpMsgQue) read() {
 		if data == nil {
 			break
 		}
-		var msg *Message
-		if r.msgTyp == MsgTypeCmd {
-			msg = &Message{Data: data}
+		if !r.recv(data) {
+			break
+		}
+	}
+}
+
+func (r *udpMsgQue) recv(data []byte) bool {
+	var msg *Message
+	if r.msgTyp == MsgTypeCmd {
+		msg = &Message{Data: data}
+	} else {
+		head := MessageHeadFromByte(data)
+		if head == nil {
+			return false
+		}
+		if head.Len > 0 {
+			msg = &Message{Head: head, Data: data[MsgHeadSize:]}
 		} else {
-			head := MessageHeadFromByte(data)
-			if head == nil {
-				break
-			}
-			if head.Len > 0 {
-				msg = &Message{Head: head, Data: data[MsgHeadSize:]}
-			} else {
-				msg = &Message{Head: head}
-			}
+			msg = &Message{Head: head}
 		}
-		r.lastTick = Timestamp
-		if !r.init {
-			if !r.handler.OnNewMsgQue(r) {
-				break
-			}
-			r.init = true
+	}
+	r.lastTick = GetNowTick()
+	if !r.init {
+		if !r.handler.OnNewMsgQue(r) {
+			return false
 		}
+		r.init = true
+		if UDPCookie {
+			r.donePending() // the cookie proved the address
+		}
+	} else {
+		r.donePending() // without the cookie the address is trusted on its second datagram
+	}
 
-		if !r.processMsg(r, msg) {
-			break
+	return r.processMsg(r, msg)
+}
+
+// recvInline processes data in the listen goroutine, used by reactor mode
+func (r *udpMsgQue) recvInline(data []byte) {
+	pData := make([]byte, len(data))
+	copy(pData, data)
+	r.recvLock.Lock()
+	ok := !r.IsStop() && r.recv(pData)
+	r.recvLock.Unlock()
+	if !ok {
+		r.Stop()
+	}
+}
+
+func (r *udpMsgQue) sendInline(m *Message) bool {
+	var err error
+	if r.msgTyp == MsgTypeCmd {
+		if m.Data != nil {
+			err = r.writeTo(m.Data)
+		}
+	} else {
+		if m.Head != nil || m.Data != nil {
+			err = r.writeTo(m.Bytes())
 		}
 	}
+	r.lastTick = GetNowTick()
+	return err == nil
 }
 
 func (r *udpMsgQue) write() {
 	defer func() {
+		atomic.StoreInt32(&r.writeExit, 1)
 		if err := recover(); err != nil {
 			Errorf("msgQue write panic id:%v err:%v", r.id, err.(error))
 			LogStack()
@@ -120,16 +207,35 @@ func (r *udpMsgQue) write() {
 	}()
 
 	timeoutCheck := false
-	tick := time.NewTimer(time.Second * time.Duration(r.timeout))
+	timeout := int64(r.readTimeout / time.Millisecond)
+	// driven by the timing wheel so sessions follow the injected clock, a nil tickCh never fires
+	var tickCh chan struct{}
+	var tick *Timer
+	if timeout > 0 {
+		tickCh = make(chan struct{}, 1)
+		tick = r.app.AddTimer(int(timeout), 0, func() {
+			select {
+			case tickCh <- struct{}{}:
+			default:
+			}
+		}, TimerInline)
+		defer tick.Cancel()
+	}
 	for !r.IsStop() {
 		var m *Message
-		select {
-		case m = <-r.writeCh:
-		case <-tick.C:
-			left := int(Timestamp - r.lastTick)
-			if left < r.timeout {
-				timeoutCheck = true
-				tick = time.NewTimer(time.Second * time.Duration(r.timeout-left))
+		if ch := r.pickLane(); ch != nil {
+			m = <-ch
+		} else {
+			select {
+			case m = <-r.highCh:
+			case m = <-r.writeCh:
+			case m = <-r.lowCh:
+			case <-tickCh:
+				left := GetNowTick() - r.lastTick
+				if left < timeout {
+					timeoutCheck = true
+					tick.Reset(int(timeout - left))
+				}
 			}
 		}
 		if timeoutCheck {
@@ -142,16 +248,29 @@ func (r *udpMsgQue) write() {
 
 		if r.msgTyp == MsgTypeCmd {
 			if m.Data != nil {
-				r.conn.WriteToUDP(m.Data, r.addr)
+				r.writeTo(m.Data)
 			}
 		} else {
 			if m.Head != nil || m.Data != nil {
-				r.conn.WriteToUDP(m.Bytes(), r.addr)
+				r.writeTo(m.Bytes())
 			}
 		}
+		r.written(r.msgSize(m), true)
+
+		r.lastTick = GetNowTick()
+	}
+}
 
-		r.lastTick = Timestamp
+func (r *udpMsgQue) writeTo(data []byte) error {
+	if r.batcher != nil {
+		err := r.batcher.send(udpPacketData(r.connID, data), r.getAddr())
+		if err == ErrUDPSendDropped {
+			atomic.AddInt32(&r.app.stat.UDPDropCount, 1)
+		}
+		return err
 	}
+	_, err := writeUDP(r.conn, r.connID, data, r.getAddr())
+	return err
 }
 
 func (r *udpMsgQue) sendRead(data []byte, n int) (re bool) {
@@ -170,43 +289,166 @@ func (r *udpMsgQue) sendRead(data []byte, n int) (re bool) {
 	return
 }
 
-var udpMap = map[string]*udpMsgQue{}
-var udpMapLock sync.Mutex
+type udpPacket struct {
+	data []byte
+	addr *net.UDPAddr
+}
+
+// udpBatcher collects datagrams of all sessions of a listen socket and sends them in batches
+type udpBatcher struct {
+	app    *App
+	conn   *net.UDPConn
+	family uint16 // address family of conn, looked up once
+	size   int
+	ch     chan udpPacket
+}
+
+func (r *udpBatcher) send(data []byte, addr *net.UDPAddr) error {
+	select {
+	case r.ch <- udpPacket{data: data, addr: addr}:
+		return nil
+	default:
+		return ErrUDPSendDropped
+	}
+}
+
+func (r *udpBatcher) loop(stopCh chan struct{}) {
+	packets := make([]udpPacket, 0, r.size)
+	for {
+		select {
+		case <-stopCh:
+			return
+		case p := <-r.ch:
+			packets = append(packets[:0], p)
+		}
+	collect:
+		for len(packets) < r.size {
+			select {
+			case p := <-r.ch:
+				packets = append(packets, p)
+			default:
+				break collect
+			}
+		}
+		if failed := sendUDPBatch(r.conn, r.family, packets); failed > 0 {
+			atomic.AddInt32(&r.app.stat.UDPDropCount, int32(failed))
+		}
+	}
+}
+
+func newUDPBatcher(app *App, conn *net.UDPConn, size int) *udpBatcher {
+	batcher := &udpBatcher{app: app, conn: conn, family: udpFamily(conn), size: size, ch: make(chan udpPacket, size*16)}
+	app.Go2(batcher.loop)
+	return batcher
+}
 
 func (r *udpMsgQue) listenTrue() {
-	data := make([]byte, 1<<16)
+	batch := newUDPReadBatch(UDPBatchSize)
 	for !r.IsStop() {
 		r.Lock()
-		n, addr, err := r.conn.ReadFromUDP(data)
+		cnt, err := batch.read(r.conn)
 		r.Unlock()
 		if err != nil {
-			if err.(net.Error).Timeout() {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
 				continue
 			}
 			break
 		}
 
-		if n <= 0 {
-			continue
+		for i := 0; i < cnt; i++ {
+			if batch.sizes[i] > 0 {
+				r.dispatch(batch.bufs[i][:batch.sizes[i]], batch.addrs[i])
+			}
 		}
+	}
+}
 
-		udpMapLock.Lock()
-		msgQue, ok := udpMap[addr.String()]
-		if !ok {
-			msgQue = newUDPAccept(r.conn, r.msgTyp, r.handler, r.parserFactory, addr)
-			udpMap[addr.String()] = msgQue
+func (r *udpMsgQue) dispatch(buf []byte, addr *net.UDPAddr) {
+	var connID uint64
+	if UDPConnID {
+		if len(buf) < UDPConnIDSize {
+			return
 		}
-		udpMapLock.Unlock()
+		connID = binary.BigEndian.Uint64(buf)
+		buf = buf[UDPConnIDSize:]
+	}
+
+	msgQue, buf, drop := r.lookup(connID, addr, buf)
+	if drop {
+		return
+	}
+	if msgQue == nil {
+		if msgQue, buf = r.accept(buf, addr); msgQue == nil {
+			return
+		}
+	}
+
+	if len(buf) > 0 && msgQue.readCh == nil {
+		msgQue.recvInline(buf)
+	} else if len(buf) > 0 && !msgQue.sendRead(buf, len(buf)) {
+		Errorf("drop msg because msgQue full msgqueid:%v", msgQue.id)
+	}
+}
 
-		if !msgQue.sendRead(data, n) {
-			Errorf("drop msg because msgQue full msgqueid:%v", msgQue.id)
+func (r *udpMsgQue) accept(data []byte, addr *net.UDPAddr) (*udpMsgQue, []byte) {
+	if UDPCookie {
+		payload, ok := checkUDPCookie(data, addr, 0)
+		if !ok {
+			if len(data) >= UDPCookieSize {
+				writeUDP(r.conn, 0, newUDPCookie(addr, 0), addr)
+			}
+			return nil, nil
+		}
+		data = payload
+	}
+	r.app.udpMapLock.Lock()
+	defer r.app.udpMapLock.Unlock()
+	if UDPConnID {
+		if msgQue, ok := r.app.udpAddrMap[addr.String()]; ok {
+			return msgQue, data
 		}
+	} else if msgQue, ok := r.app.udpMap[addr.String()]; ok {
+		return msgQue, data
 	}
+	if MaxUDPPendingSession > 0 && int(atomic.LoadInt32(&r.app.udpPendingCount)) >= MaxUDPPendingSession {
+		atomic.AddInt32(&r.app.stat.RejectPendingCount, 1)
+		return nil, nil
+	}
+	key, ok := r.app.limiter.acquire(addr)
+	if !ok {
+		return nil, nil
+	}
+	atomic.AddInt32(&r.app.udpPendingCount, 1)
+	var connID uint64
+	if UDPConnID {
+		connID = r.app.newUDPConnID()
+	}
+	msgQue := newUDPAccept(r.app, r.conn, r.msgTyp, r.handler, r.parserFactory, addr, connID, r.reactor, r.opts)
+	msgQue.limited = true
+	msgQue.limitKey = key
+	msgQue.batcher = r.batcher
+	r.app.udpMap[msgQue.mapKey()] = msgQue
+	if UDPConnID {
+		r.app.udpAddrMap[addr.String()] = msgQue
+	}
+	return msgQue, data
 }
 
 func (r *udpMsgQue) listen() {
-	for i := 0; i < UDPServerGoCnt; i++ {
-		Go(func() {
+	if r.reactor {
+		r.app.Go(func() {
+			r.checkTimeout()
+		})
+	}
+	if UDPBatchSize > 1 {
+		r.batcher = newUDPBatcher(r.app, r.conn, UDPBatchSize)
+	}
+	goCnt := r.goCnt
+	if goCnt <= 0 {
+		goCnt = UDPServerGoCnt
+	}
+	for i := 0; i < goCnt; i++ {
+		r.app.Go(func() {
 			r.listenTrue()
 		})
 	}
@@ -214,36 +456,67 @@ func (r *udpMsgQue) listen() {
 	r.Stop()
 }
 
-func newUDPAccept(conn *net.UDPConn, msgtyp MsgType, handler IMsgHandler, parser *Parser, addr *net.UDPAddr) *udpMsgQue {
+// checkTimeout stops idle sessions which have no goroutine of their own in reactor mode
+func (r *udpMsgQue) checkTimeout() {
+	for !r.IsStop() {
+		Sleep(1000)
+		var idle []*udpMsgQue
+		r.app.udpMapLock.Lock()
+		for _, v := range r.app.udpMap {
+			if timeout := int64(v.readTimeout / time.Millisecond); v.reactor && timeout > 0 && GetNowTick()-v.lastTick >= timeout {
+				idle = append(idle, v)
+			}
+		}
+		r.app.udpMapLock.Unlock()
+		for _, v := range idle {
+			Infof("msgQue:%d timeout", v.id)
+			v.Stop()
+		}
+	}
+}
+
+func newUDPAccept(app *App, conn *net.UDPConn, msgtyp MsgType, handler IMsgHandler, parser *Parser, addr *net.UDPAddr, connID uint64, reactor bool, opts *ConnOptions) *udpMsgQue {
 	msgQue := udpMsgQue{
 		msgQue: msgQue{
 			id:            atomic.AddUint32(&msgQueID, 1),
-			writeCh:       make(chan *Message, 64),
+			app:           app,
 			msgTyp:        msgtyp,
 			handler:       handler,
 			available:     true,
-			timeout:       DefMsgQueTimeout,
+			readTimeout:   opts.readTimeout(),
+			writeTimeout:  opts.writeTimeout(),
 			connTyp:       ConnTypeAccept,
 			parserFactory: parser,
+			opts:          opts,
+			reactor:       reactor,
 		},
 		conn:     conn,
-		readCh:   make(chan []byte, 64),
 		addr:     addr,
-		lastTick: Timestamp,
+		connID:   connID,
+		lastTick: GetNowTick(),
+		pending:  1,
 	}
 	if parser != nil {
 		msgQue.parser = parser.Get()
 	}
-	msgQueMapSync.Lock()
-	msgQueMap[msgQue.id] = &msgQue
-	msgQueMapSync.Unlock()
+	app.addMsgQue(&msgQue)
 
-	Go(func() {
+	if reactor {
+		msgQue.sender = msgQue.sendInline
+		Infof("new msgQue id:%d from addr:%s", msgQue.id, addr.String())
+		return &msgQue
+	}
+
+	msgQue.writeCh = make(chan *Message, 64)
+	msgQue.highCh = make(chan *Message, MsgPriorityLaneSize)
+	msgQue.lowCh = make(chan *Message, MsgPriorityLaneSize)
+	msgQue.readCh = make(chan []byte, 64)
+	app.Go(func() {
 		Infof("process read for msgQue:%d", msgQue.id)
 		msgQue.read()
 		Infof("process read end for msgQue:%d", msgQue.id)
 	})
-	Go(func() {
+	app.Go(func() {
 		Infof("process write for msgQue:%d", msgQue.id)
 		msgQue.write()
 		Infof("process write end for msgQue:%d", msgQue.id)
@@ -253,25 +526,31 @@ func newUDPAccept(conn *net.UDPConn, msgtyp MsgType, handler IMsgHandler, parser
 	return &msgQue
 }
 
-func newUDPListen(conn *net.UDPConn, msgtyp MsgType, handler IMsgHandler, parser *Parser, addr string) *udpMsgQue {
+func newUDPListen(app *App, conn *net.UDPConn, msgtyp MsgType, handler IMsgHandler, parser *Parser, addr string, opts *ConnOptions) *udpMsgQue {
 	msgQue := udpMsgQue{
 		msgQue: msgQue{
 			id:            atomic.AddUint32(&msgQueID, 1),
+			app:           app,
 			msgTyp:        msgtyp,
 			handler:       handler,
 			available:     true,
 			parserFactory: parser,
+			opts:          opts,
 			connTyp:       ConnTypeListen,
+			reactor:       ReactorMode,
 		},
 		conn: conn,
 	}
-	conn.SetReadBuffer(1 << 24)
-	conn.SetWriteBuffer(1 << 24)
-	msgQueMapSync.Lock()
-	msgQueMap[msgQue.id] = &msgQue
-	msgQueMapSync.Unlock()
+	opts.applyUDP(conn)
+	app.addMsgQue(&msgQue)
 	Infof("new udp listen id:%d addr:%s", msgQue.id, addr)
 	return &msgQue
 }
 
 var UDPServerGoCnt = 32
+var UDPBatchSize = 0 // datagrams read by one recvmmsg and written by one sendmmsg, values below 2 disable batching
+
+// MaxUDPPendingSession is the max udp sessions whose peer has not proved to own its address, 0 means unlimited.
+// a session is pending until its second datagram, or its first one if UDPCookie is set. a spoofed flood can still
+// send twice from one source, so only UDPCookie really bounds spoofed sessions
+var MaxUDPPendingSession = 0
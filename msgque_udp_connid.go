@@ -0,0 +1,99 @@
+package sugar
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"strconv"
+)
+
+// udp connection id mode, enabled by UDPConnID:
+// every datagram in both directions starts with an 8 bytes big endian connection id,
+// client sends 0 to open a session and then uses the id carried by server datagrams.
+// a datagram with a known id from a new address is discarded, and if it is at least UDPCookieSize bytes,
+// the server answers the new address with a cookie datagram signed over the id and that address.
+// client echoes it from the new address by id + NewUDPCookieEcho(cookie, payload),
+// then the session migrates to that address and payload is delivered.
+// the round trip proves the new address receives the traffic of the session, so spoofed source addresses
+// cannot hijack or redirect sessions, attackers able to read the traffic are only stopped by encryption
+
+const UDPConnIDSize = 8
+
+var UDPConnID = false // key udp sessions by connection id instead of address
+
+func udpConnIDKey(connID uint64) string {
+	return "#" + strconv.FormatUint(connID, 16)
+}
+
+// newUDPConnID must be invoked with udpMapLock held
+func (r *App) newUDPConnID() uint64 {
+	data := make([]byte, UDPConnIDSize)
+	for {
+		rand.Read(data)
+		connID := binary.BigEndian.Uint64(data)
+		if _, ok := r.udpMap[udpConnIDKey(connID)]; connID != 0 && !ok {
+			return connID
+		}
+	}
+}
+
+func udpPacketData(connID uint64, data []byte) []byte {
+	if !UDPConnID {
+		return data
+	}
+	buf := make([]byte, UDPConnIDSize+len(data))
+	binary.BigEndian.PutUint64(buf, connID)
+	copy(buf[UDPConnIDSize:], data)
+	return buf
+}
+
+func writeUDP(conn *net.UDPConn, connID uint64, data []byte, addr *net.UDPAddr) (int, error) {
+	return conn.WriteToUDP(udpPacketData(connID, data), addr)
+}
+
+// lookup finds the session of a datagram and returns its payload, drop is true if the datagram should be discarded
+func (r *udpMsgQue) lookup(connID uint64, addr *net.UDPAddr, data []byte) (*udpMsgQue, []byte, bool) {
+	r.app.udpMapLock.Lock()
+	if !UDPConnID {
+		msgQue := r.app.udpMap[addr.String()]
+		r.app.udpMapLock.Unlock()
+		return msgQue, data, false
+	}
+	if connID == 0 {
+		msgQue := r.app.udpAddrMap[addr.String()]
+		r.app.udpMapLock.Unlock()
+		return msgQue, data, false
+	}
+
+	msgQue, ok := r.app.udpMap[udpConnIDKey(connID)]
+	if !ok {
+		r.app.udpMapLock.Unlock()
+		return nil, nil, true
+	}
+	old := msgQue.getAddr()
+	if old.String() == addr.String() {
+		r.app.udpMapLock.Unlock()
+		return msgQue, data, false
+	}
+	payload, ok := checkUDPCookie(data, addr, connID)
+	if !ok {
+		r.app.udpMapLock.Unlock()
+		// the challenge is not larger than the datagram, so it cannot be used for amplification
+		if len(data) >= UDPCookieSize {
+			writeUDP(r.conn, connID, newUDPCookie(addr, connID), addr)
+		}
+		return nil, nil, true
+	}
+	if msgQue.limited && !r.app.limiter.move(&msgQue.limitKey, addr) {
+		r.app.udpMapLock.Unlock()
+		return nil, nil, true
+	}
+	if r.app.udpAddrMap[old.String()] == msgQue {
+		delete(r.app.udpAddrMap, old.String())
+	}
+	r.app.udpAddrMap[addr.String()] = msgQue
+	msgQue.setAddr(addr)
+	r.app.udpMapLock.Unlock()
+	Infof("msgQue:%d migrate from addr:%s to addr:%s", msgQue.id, old.String(), addr.String())
+	return msgQue, payload, false
+}
@@ -0,0 +1,69 @@
+package sugar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_UDPConnIDMigrate(t *testing.T) {
+	UDPConnID = true
+	defer func() { UDPConnID = false }()
+	app := NewApp()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	listener := newUDPListen(app, conn, MsgTypeMsg, &DefMsgHandler{}, nil, "", NewConnOptions())
+
+	first := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 5000}
+	msg := NewMsg(1, 1, 0, 0, []byte("hi")).Bytes()
+	session, _ := listener.accept(msg, first)
+	if session == nil || session.connID == 0 {
+		t.Fatal("session not created")
+	}
+	if q, data, drop := listener.lookup(session.connID, first, msg); q != session || drop || !bytes.Equal(data, msg) {
+		t.Fatal("lookup by id failed")
+	}
+	if q, _, drop := listener.lookup(session.connID+1, first, msg); q != nil || !drop {
+		t.Fatal("unknown id should be dropped")
+	}
+
+	// a well formed message from a new address does not migrate, the address is challenged instead
+	moved := client.LocalAddr().(*net.UDPAddr)
+	padded := NewMsg(1, 1, 0, 0, make([]byte, UDPCookieSize)).Bytes()
+	if q, _, drop := listener.lookup(session.connID, moved, padded); q != nil || !drop || session.getAddr() != first {
+		t.Fatal("session migrated without proof")
+	}
+	buf := make([]byte, 256)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := client.ReadFromUDP(buf)
+	if err != nil || n != UDPConnIDSize+UDPCookieSize || binary.BigEndian.Uint64(buf) != session.connID {
+		t.Fatalf("challenge not received %d %v", n, err)
+	}
+	cookie := ParseUDPCookie(buf[UDPConnIDSize:n])
+
+	// the challenge is bound to the address and the id
+	other := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 3), Port: 5000}
+	if q, _, _ := listener.lookup(session.connID, other, NewUDPCookieEcho(cookie, msg)); q != nil {
+		t.Fatal("challenge of another address accepted")
+	}
+	q, data, drop := listener.lookup(session.connID, moved, NewUDPCookieEcho(cookie, msg))
+	if q != session || drop || !bytes.Equal(data, msg) || session.getAddr().String() != moved.String() {
+		t.Fatal("echoed challenge should migrate the session")
+	}
+	if app.udpAddrMap[moved.String()] != session || app.udpAddrMap[first.String()] != nil {
+		t.Fatal("address map not updated")
+	}
+	if session.limitKey != "127.0.0.1" || app.limiter.ipCount["127.0.0.2"] != 0 {
+		t.Fatalf("limiter count not moved %v", app.limiter.ipCount)
+	}
+}
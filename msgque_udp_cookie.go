@@ -0,0 +1,104 @@
+package sugar
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// udp cookie handshake, enabled by UDPCookie:
+// cookie datagrams are a MessageHead with only FlagCookie set and Len udpCookieLen followed by the cookie,
+// FlagCookie is never set on messages, so cookie datagrams are not confused with them.
+// 1. client sends any datagram of at least UDPCookieSize bytes, e.g. NewUDPCookieEcho(nil, nil)
+// 2. server replies a cookie datagram without creating any session
+// 3. client echoes it by NewUDPCookieEcho(cookie, payload), payload is its optional first message,
+//    server validates the cookie and only then creates the udpMsgQue
+
+const (
+	UDPCookieSize = MsgHeadSize + udpCookieLen
+	udpCookieLen  = 16
+)
+
+var UDPCookie = false      // require the cookie handshake before a udp session is created
+var UDPCookieLifetime = 30 // seconds a cookie stays valid
+
+var udpCookieSecret []byte
+var udpCookieLock sync.RWMutex
+
+// SetUDPCookieSecret replaces the secret used to sign cookies, a random one is used by default
+func SetUDPCookieSecret(secret []byte) {
+	udpCookieLock.Lock()
+	udpCookieSecret = secret
+	udpCookieLock.Unlock()
+}
+
+func isUDPCookie(data []byte) bool {
+	if len(data) < UDPCookieSize {
+		return false
+	}
+	head := MessageHeadFromByte(data)
+	return head != nil && head.Flags == FlagCookie && head.Len == udpCookieLen && head.Cmd == 0 && head.Act == 0 && head.Error == 0
+}
+
+// ParseUDPCookie returns the cookie if data is a cookie reply, used by clients
+func ParseUDPCookie(data []byte) []byte {
+	if len(data) != UDPCookieSize || !isUDPCookie(data) {
+		return nil
+	}
+	return data[MsgHeadSize:]
+}
+
+// NewUDPCookieEcho builds the datagram echoing cookie, payload is delivered as the first message
+func NewUDPCookieEcho(cookie, payload []byte) []byte {
+	data := make([]byte, UDPCookieSize, UDPCookieSize+len(payload))
+	copy(data, (&MessageHead{Len: udpCookieLen, Flags: FlagCookie}).Bytes())
+	copy(data[MsgHeadSize:], cookie)
+	return append(data, payload...)
+}
+
+// udpCookieSign signs addr in slot, connID is 0 for the handshake or the session id for migration
+func udpCookieSign(addr *net.UDPAddr, slot int64, connID uint64) []byte {
+	udpCookieLock.RLock()
+	mac := hmac.New(sha256.New, udpCookieSecret)
+	udpCookieLock.RUnlock()
+	buf := make([]byte, 18)
+	binary.BigEndian.PutUint64(buf, uint64(slot))
+	binary.BigEndian.PutUint16(buf[8:], uint16(addr.Port))
+	binary.BigEndian.PutUint64(buf[10:], connID)
+	mac.Write(addr.IP.To16())
+	mac.Write(buf)
+	return mac.Sum(nil)[:udpCookieLen]
+}
+
+func udpCookieSlot() int64 {
+	lifetime := int64(UDPCookieLifetime)
+	if lifetime <= 0 {
+		lifetime = 30
+	}
+	return GetTimestamp() / lifetime
+}
+
+func newUDPCookie(addr *net.UDPAddr, connID uint64) []byte {
+	return NewUDPCookieEcho(udpCookieSign(addr, udpCookieSlot(), connID), nil)
+}
+
+// checkUDPCookie validates the cookie echoed by addr and returns the remaining payload
+func checkUDPCookie(data []byte, addr *net.UDPAddr, connID uint64) ([]byte, bool) {
+	if !isUDPCookie(data) {
+		return nil, false
+	}
+	cookie := data[MsgHeadSize:UDPCookieSize]
+	slot := udpCookieSlot()
+	if hmac.Equal(cookie, udpCookieSign(addr, slot, connID)) || hmac.Equal(cookie, udpCookieSign(addr, slot-1, connID)) {
+		return data[UDPCookieSize:], true
+	}
+	return nil, false
+}
+
+func init() {
+	udpCookieSecret = make([]byte, 32)
+	rand.Read(udpCookieSecret)
+}
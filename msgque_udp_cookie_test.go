@@ -0,0 +1,78 @@
+package sugar
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_UDPCookie(t *testing.T) {
+	clock := NewManualClock(time.Unix(1700000000, 0))
+	SetClock(clock)
+	defer SetClock(nil)
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4000}
+
+	reply := newUDPCookie(addr, 0)
+	cookie := ParseUDPCookie(reply)
+	if cookie == nil {
+		t.Fatal("reply should be parsed as cookie")
+	}
+	payload, ok := checkUDPCookie(NewUDPCookieEcho(cookie, []byte("hello")), addr, 0)
+	if !ok || string(payload) != "hello" {
+		t.Fatal("echoed cookie should be accepted with payload")
+	}
+	if _, ok := checkUDPCookie(NewUDPCookieEcho(cookie, nil), &net.UDPAddr{IP: addr.IP, Port: 4001}, 0); ok {
+		t.Fatal("cookie of another address should be rejected")
+	}
+	tampered := append([]byte{}, cookie...)
+	tampered[0] ^= 1
+	if _, ok := checkUDPCookie(NewUDPCookieEcho(tampered, nil), addr, 0); ok {
+		t.Fatal("tampered cookie should be rejected")
+	}
+
+	// a cookie stays valid in its slot and the next one
+	clock.Advance(time.Duration(UDPCookieLifetime) * time.Second)
+	if _, ok := checkUDPCookie(NewUDPCookieEcho(cookie, nil), addr, 0); !ok {
+		t.Fatal("cookie of previous slot should be accepted")
+	}
+	clock.Advance(time.Duration(UDPCookieLifetime) * time.Second)
+	if _, ok := checkUDPCookie(NewUDPCookieEcho(cookie, nil), addr, 0); ok {
+		t.Fatal("expired cookie should be rejected")
+	}
+
+	// a message of the same size is not a cookie
+	msg := NewMsg(1, 1, 0, 0, make([]byte, udpCookieLen)).Bytes()
+	if len(msg) != UDPCookieSize || ParseUDPCookie(msg) != nil {
+		t.Fatal("message should not be parsed as cookie")
+	}
+}
+
+func Test_UDPPendingSession(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	MaxUDPPendingSession = 1
+	defer func() { MaxUDPPendingSession = 0 }()
+	app := NewApp()
+	listener := &udpMsgQue{msgQue: msgQue{app: app, msgTyp: MsgTypeMsg, handler: &DefMsgHandler{}, opts: NewConnOptions(), reactor: true}, conn: conn}
+	a := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 5000}
+	b := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 3), Port: 5000}
+	data := NewMsg(1, 1, 0, 0, nil).Bytes()
+
+	// a single datagram does not prove the address, so its session stays pending
+	qa, _ := listener.accept(data, a)
+	if qa == nil || !qa.recv(data) {
+		t.Fatal("first session rejected")
+	}
+	if q, _ := listener.accept(data, b); q != nil || app.GetStat().RejectPendingCount != 1 {
+		t.Fatal("pending session not capped")
+	}
+	if q, _ := listener.accept(data, a); q != qa || !qa.recv(data) {
+		t.Fatal("datagram of pending session rejected")
+	}
+	if q, _ := listener.accept(data, b); q == nil {
+		t.Fatal("session rejected after the pending one proved its address")
+	}
+}
@@ -0,0 +1,185 @@
+package sugar
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsOpContinue = 0x0
+	wsOpText     = 0x1
+	wsOpBinary   = 0x2
+	wsOpClose    = 0x8
+	wsOpPing     = 0x9
+	wsOpPong     = 0xa
+)
+
+// wsConn turns websocket frames into the byte stream tcpMsgQue expects,
+// each sugar message is sent as one binary frame, each cmd line as one text frame
+type wsConn struct {
+	net.Conn
+	reader    *bufio.Reader
+	msgTyp    MsgType
+	payload   []byte
+	writeBuf  []byte
+	writeLock sync.Mutex
+	closed    bool
+}
+
+func acceptWebSocket(c net.Conn, br *bufio.Reader, path string, msgTyp MsgType) (*wsConn, error) {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, err
+	}
+	key := req.Header.Get("Sec-Websocket-Key")
+	if req.Method != "GET" || key == "" || req.Header.Get("Sec-Websocket-Version") != "13" ||
+		!strings.EqualFold(req.Header.Get("Upgrade"), "websocket") || (path != "" && req.URL.Path != path) {
+		c.Write([]byte("HTTP/1.1 400 Bad Request\r\nConnection: close\r\n\r\n"))
+		return nil, ErrWSHandshake
+	}
+	h := sha1.Sum([]byte(key + wsGUID))
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: " +
+		base64.StdEncoding.EncodeToString(h[:]) + "\r\n\r\n"
+	if _, err := c.Write([]byte(resp)); err != nil {
+		return nil, err
+	}
+	return &wsConn{Conn: c, reader: br, msgTyp: msgTyp}, nil
+}
+
+func (r *wsConn) Read(p []byte) (int, error) {
+	for len(r.payload) == 0 {
+		if r.closed {
+			return 0, io.EOF
+		}
+		if err := r.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.payload)
+	r.payload = r.payload[n:]
+	return n, nil
+}
+
+func (r *wsConn) readFrame() error {
+	var head [2]byte
+	if _, err := io.ReadFull(r.reader, head[:]); err != nil {
+		return err
+	}
+	fin, op := head[0]&0x80 != 0, head[0]&0x0f
+	masked := head[1]&0x80 != 0
+	size := uint64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r.reader, ext[:]); err != nil {
+			return err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r.reader, ext[:]); err != nil {
+			return err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if size > uint64(MaxMsgDataSize)+MsgHeadSize {
+		return ErrMsgLenTooLong
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r.reader, mask[:]); err != nil {
+			return err
+		}
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return err
+	}
+	if masked {
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+
+	switch op {
+	case wsOpContinue, wsOpText, wsOpBinary:
+		if fin && r.msgTyp == MsgTypeCmd && (len(data) == 0 || data[len(data)-1] != '\n') {
+			data = append(data, '\n')
+		}
+		r.payload = data
+	case wsOpPing:
+		return r.writeFrame(wsOpPong, data)
+	case wsOpClose:
+		r.closed = true
+		r.writeFrame(wsOpClose, data)
+	}
+	return nil
+}
+
+func (r *wsConn) writeFrame(op byte, data []byte) error {
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+	head := make([]byte, 2, 10+len(data))
+	head[0] = 0x80 | op
+	switch size := len(data); {
+	case size < 126:
+		head[1] = byte(size)
+	case size <= 0xffff:
+		head[1] = 126
+		head = append(head, byte(size>>8), byte(size))
+	default:
+		head[1] = 127
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(size))
+		head = append(head, ext[:]...)
+	}
+	_, err := r.Conn.Write(append(head, data...))
+	return err
+}
+
+// CloseWrite sends close frame then half closes the connection
+func (r *wsConn) CloseWrite() error {
+	r.writeFrame(wsOpClose, nil)
+	if c, ok := r.Conn.(closeWriter); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
+
+// Write buffers until a whole sugar message arrives, tcpMsgQue writes head and data separately
+func (r *wsConn) Write(p []byte) (int, error) {
+	if r.msgTyp == MsgTypeCmd {
+		if err := r.writeFrame(wsOpText, p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	r.writeBuf = append(r.writeBuf, p...)
+	for len(r.writeBuf) >= MsgHeadSize {
+		head := NewMessageHead(r.writeBuf[:MsgHeadSize])
+		if head == nil {
+			return 0, ErrMsgLenTooLong
+		}
+		size := MsgHeadSize + int(head.Len)
+		if len(r.writeBuf) < size {
+			break
+		}
+		if err := r.writeFrame(wsOpBinary, r.writeBuf[:size]); err != nil {
+			return 0, err
+		}
+		r.writeBuf = r.writeBuf[size:]
+	}
+	if len(r.writeBuf) == 0 {
+		r.writeBuf = nil
+	}
+	return len(p), nil
+}
@@ -1,27 +1,108 @@
 package sugar
 
 import (
-	"net"
+	"encoding/json"
 	"strings"
 	"sync"
 	"sync/atomic"
 
 	"github.com/go-redis/redis"
+	"github.com/golang/protobuf/proto"
+	"github.com/vmihailenco/msgpack"
 )
 
+const (
+	RedisStandalone = iota
+	RedisSentinel
+	RedisCluster
+)
+
+// RedisConfig describes a standalone redis by Addr, a sentinel deployment by MasterName and
+// sentinel Addrs, or a cluster by Cluster and node Addrs
 type RedisConfig struct {
-	Addr     string
-	Password string
-	PoolSize int
+	Addr       string
+	Password   string
+	PoolSize   int
+	DB         int      // ignored by cluster
+	MasterName string   // sentinel master name
+	Addrs      []string // sentinel addresses or cluster seed nodes, Addr is used if empty
+	Cluster    bool
+}
+
+// Mode returns RedisStandalone, RedisSentinel or RedisCluster
+func (r *RedisConfig) Mode() int {
+	if r.MasterName != "" {
+		return RedisSentinel
+	} else if r.Cluster {
+		return RedisCluster
+	}
+	return RedisStandalone
+}
+
+func (r *RedisConfig) addrs() []string {
+	if len(r.Addrs) > 0 {
+		return r.Addrs
+	}
+	return []string{r.Addr}
+}
+
+// String identifies the deployment, redis instances of one deployment share a subscription
+func (r *RedisConfig) String() string {
+	switch r.Mode() {
+	case RedisSentinel:
+		return "sentinel:" + r.MasterName + "@" + strings.Join(r.addrs(), ",")
+	case RedisCluster:
+		return "cluster:" + strings.Join(r.addrs(), ",")
+	}
+	return r.Addr
+}
+
+func (r *RedisConfig) newClient() redis.UniversalClient {
+	switch r.Mode() {
+	case RedisSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    r.MasterName,
+			SentinelAddrs: r.addrs(),
+			Password:      r.Password,
+			DB:            r.DB,
+			PoolSize:      r.PoolSize,
+		})
+	case RedisCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:    r.addrs(),
+			Password: r.Password,
+			PoolSize: r.PoolSize,
+		})
+	}
+	return redis.NewClient(&redis.Options{
+		Addr:     r.Addr,
+		Password: r.Password,
+		DB:       r.DB,
+		PoolSize: r.PoolSize,
+	})
 }
 
+// Redis embeds redis.UniversalClient so commands work on every deployment.
+// it embedded *redis.Client before sentinel and cluster were supported, Client is kept for code using it,
+// but methods only *redis.Client has are not promoted anymore and Client is nil for a cluster
 type Redis struct {
-	*redis.Client
+	redis.UniversalClient
+	Client  *redis.Client // the client of a standalone or sentinel deployment, nil for a cluster
 	pubSub  *redis.PubSub
 	conf    *RedisConfig
 	manager *RedisManager
 }
 
+func newRedis(conf *RedisConfig, manager *RedisManager) *Redis {
+	re := &Redis{
+		UniversalClient: conf.newClient(),
+		conf:            conf,
+		manager:         manager,
+	}
+	re.Client, _ = re.UniversalClient.(*redis.Client)
+	return re
+}
+
 func (r *Redis) ScriptStr(cmd int, keys []string, args ...interface{}) (string, error) {
 	data, err := r.Script(cmd, keys, args...)
 	if err != nil {
@@ -67,6 +148,73 @@ func (r *Redis) ScriptStrArray(cmd int, keys []string, args ...interface{}) ([]s
 	return strArray, nil
 }
 
+// ScriptInto decodes the script result into out by its type, proto messages are packed by cmsgpack.pack
+// as RedisModel stores them, others are encoded by cjson.encode. results like integers and arrays
+// returned directly are converted as JSON.
+// caution: empty lua tables are encoded by cjson as {} but not [].
+// Errorz are returned by redis.error_reply(ScriptErrPrefix .. id) instead of integers,
+// ErrDBDataType is returned if the result doesn't fit out
+func (r *Redis) ScriptInto(cmd int, keys []string, args []interface{}, out interface{}) error {
+	data, err := r.Script(cmd, keys, args...)
+	if err != nil {
+		return err
+	}
+	return decodeScriptResult(data, out)
+}
+
+// ScriptIntoJSON is ScriptInto with a result encoded by cjson.encode, proto messages are decoded by
+// their json tags but not jsonpb, since lua can't produce the string form jsonpb expects for int64
+func (r *Redis) ScriptIntoJSON(cmd int, keys []string, args []interface{}, out interface{}) error {
+	data, err := r.Script(cmd, keys, args...)
+	if err != nil {
+		return err
+	}
+	return decodeScriptJSON(data, out)
+}
+
+// ScriptIntoMsgpack is ScriptInto with a result packed by cmsgpack.pack
+func (r *Redis) ScriptIntoMsgpack(cmd int, keys []string, args []interface{}, out interface{}) error {
+	data, err := r.Script(cmd, keys, args...)
+	if err != nil {
+		return err
+	}
+	return decodeScriptMsgpack(data, out)
+}
+
+// decodeScriptResult picks the codec of ScriptInto by the type of out
+func decodeScriptResult(data interface{}, out interface{}) error {
+	if _, ok := out.(proto.Message); ok {
+		return decodeScriptMsgpack(data, out)
+	}
+	return decodeScriptJSON(data, out)
+}
+
+func decodeScriptJSON(data interface{}, out interface{}) error {
+	str, ok := data.(string)
+	if !ok {
+		buf, err := json.Marshal(data)
+		if err != nil {
+			return ErrDBDataType
+		}
+		str = string(buf)
+	}
+	if json.Unmarshal([]byte(str), out) != nil {
+		return ErrDBDataType
+	}
+	return nil
+}
+
+func decodeScriptMsgpack(data interface{}, out interface{}) error {
+	str, ok := data.(string)
+	if !ok {
+		return decodeScriptJSON(data, out)
+	}
+	if msgpack.Unmarshal([]byte(str), out) != nil {
+		return ErrDBDataType
+	}
+	return nil
+}
+
 func (r *Redis) ScriptInt64(cmd int, keys []string, args ...interface{}) (int64, error) {
 	data, err := r.Script(cmd, keys, args...)
 	if err != nil {
@@ -79,39 +227,27 @@ func (r *Redis) ScriptInt64(cmd int, keys []string, args ...interface{}) (int64,
 	return 0, ErrDBDataType
 }
 
-func (r *Redis) Script(cmd int, keys []string, args ...interface{}) (interface{}, error) {
-	hash, _ := scriptHashMap[cmd]
-	re, err := r.EvalSha(hash, keys, args...).Result()
-	if err != nil {
-		script, ok := scriptMap[cmd]
-		if !ok {
-			return nil, err
-		}
-
-		if strings.HasPrefix(err.Error(), "NOSCRIPT ") {
-			Infof("try reload redis script %v", scriptCommitMap[cmd])
-			hash, err = r.ScriptLoad(script).Result()
-			if err != nil {
-				return nil, err
-			}
-			scriptHashMap[cmd] = hash
-			re, err = r.EvalSha(hash, keys, args...).Result()
-			if err == nil {
-				return re, nil
-			}
-		}
-		return nil, err
+// ForEachMaster invokes fn on every master of a cluster, or on the client itself otherwise
+func (r *Redis) ForEachMaster(fn func(client *redis.Client) error) error {
+	switch c := r.UniversalClient.(type) {
+	case *redis.ClusterClient:
+		return c.ForEachMaster(fn)
+	case *redis.Client:
+		return fn(c)
 	}
-
-	return re, nil
+	return nil
 }
 
 type RedisManager struct {
 	dbs      map[int]*Redis
-	subMap   map[string]*Redis
-	channels []string
-	fun      func(channel, data string)
+	subMap   map[string]*Redis // one redis of each deployment receiving subscriptions
+	channels []string          // channels of Sub
+	handlers map[string]func(channel, data string)
+	patterns map[string]func(channel, data string)
+	subLock  sync.Mutex
+	done     chan struct{}
 	lock     sync.RWMutex
+	app      *App
 }
 
 func (r *RedisManager) GetByRid(rid int) *Redis {
@@ -125,30 +261,6 @@ func (r *RedisManager) GetGlobal() *Redis {
 	return r.GetByRid(0)
 }
 
-func (r *RedisManager) Sub(fun func(channel, data string), channels ...string) {
-	r.channels = channels
-	r.fun = fun
-	for _, v := range r.subMap {
-		if v.pubSub != nil {
-			v.pubSub.Close()
-		}
-	}
-	for _, v := range r.subMap {
-		pubSub := v.Subscribe(channels...)
-		v.pubSub = pubSub
-		goForRedis(func() {
-			for IsRunning() {
-				msg, err := pubSub.ReceiveMessage()
-				if err == nil {
-					Go(func() { fun(msg.Channel, msg.Payload) })
-				} else if _, ok := err.(net.Error); !ok {
-					break
-				}
-			}
-		})
-	}
-}
-
 func (r *RedisManager) Exist(id int) bool {
 	r.lock.Lock()
 	_, ok := r.dbs[id]
@@ -164,71 +276,59 @@ func (r *RedisManager) Add(id int, conf *RedisConfig) {
 		return
 	}
 	r.lock.Unlock()
-	re := &Redis{
-		Client: redis.NewClient(&redis.Options{
-			Addr:     conf.Addr,
-			Password: conf.Password,
-			PoolSize: conf.PoolSize,
-		}),
-		conf:    conf,
-		manager: r,
-	}
+	re := newRedis(conf, r)
 
-	if _, ok := r.subMap[conf.Addr]; !ok {
-		r.subMap[conf.Addr] = re
-		if len(r.channels) > 0 {
-			pubSub := re.Subscribe(r.channels...)
-			re.pubSub = pubSub
-			goForRedis(func() {
-				for IsRunning() {
-					msg, err := pubSub.ReceiveMessage()
-					if err == nil {
-						Go(func() { r.fun(msg.Channel, msg.Payload) })
-					} else if _, ok := err.(net.Error); !ok {
-						break
-					}
-				}
-			})
+	r.subLock.Lock()
+	if _, ok := r.subMap[conf.String()]; !ok {
+		r.subMap[conf.String()] = re
+		if len(r.handlers) > 0 || len(r.patterns) > 0 {
+			r.listen(re)
 		}
 	}
+	r.subLock.Unlock()
 
 	r.lock.Lock()
 	r.dbs[id] = re
 	r.lock.Unlock()
-	Infof("connect to redis %v", conf.Addr)
+	Infof("connect to redis %v", conf)
+	if err := re.PreloadScripts(); err != nil {
+		Warnf("preload redis scripts to %v failed, err:%v", conf, err)
+	}
 }
 
 func (r *RedisManager) close() {
-	for _, v := range r.dbs {
+	r.subLock.Lock()
+	close(r.done)
+	for _, v := range r.subMap {
 		if v.pubSub != nil {
 			v.pubSub.Close()
 		}
+	}
+	r.subLock.Unlock()
+	for _, v := range r.dbs {
 		v.Close()
 	}
 }
 
-var (
-	scriptMap       = map[int]string{}
-	scriptCommitMap = map[int]string{}
-	scriptHashMap   = map[int]string{}
-	scriptIndex     int32
-)
-
-func NewRedisScript(commit, str string) int {
-	cmd := int(atomic.AddInt32(&scriptIndex, 1))
-	scriptMap[cmd] = str
-	scriptCommitMap[cmd] = commit
-	return cmd
+func NewRedisManager(conf *RedisConfig) *RedisManager {
+	return DefApp.NewRedisManager(conf)
 }
 
-func NewRedisManager(conf *RedisConfig) *RedisManager {
+// NewRedisManager creates a redis manager closed when the app exits
+func (r *App) NewRedisManager(conf *RedisConfig) *RedisManager {
 	redisManager := &RedisManager{
-		subMap: map[string]*Redis{},
-		dbs:    map[int]*Redis{},
+		subMap:   map[string]*Redis{},
+		dbs:      map[int]*Redis{},
+		handlers: map[string]func(channel, data string){},
+		patterns: map[string]func(channel, data string){},
+		done:     make(chan struct{}),
+		app:      r,
 	}
 
 	redisManager.Add(0, conf)
-	redisManagers = append(redisManagers, redisManager)
+	r.redisLock.Lock()
+	r.redisManagers = append(r.redisManagers, redisManager)
+	r.redisLock.Unlock()
 	return redisManager
 }
 
@@ -239,20 +339,17 @@ func RedisError(err error) bool {
 	return err != nil
 }
 
-func goForRedis(fn func()) {
-	waitAllForRedis.Add(1)
+func (r *App) goForRedis(fn func()) {
+	r.waitAllForRedis.Add(1)
 	id := atomic.AddUint64(&goID, 1)
-	c := atomic.AddInt64(&goCount, 1)
+	c := atomic.AddInt64(&r.goCount, 1)
 	DebugRoutineStartStack(id, c)
 
 	go func() {
-		Try(fn, nil)
+		r.Try(fn, nil)
 
-		waitAllForRedis.Done()
-		c = atomic.AddInt64(&goCount, -1)
+		r.waitAllForRedis.Done()
+		c = atomic.AddInt64(&r.goCount, -1)
 		DebugRoutineEndStack(id, c)
 	}()
 }
-
-var waitAllForRedis sync.WaitGroup
-var redisManagers []*RedisManager
@@ -0,0 +1,239 @@
+package sugar
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the lock key and its fencing counter share a hash tag so they live in one cluster slot
+var (
+	lockScriptAcquire = NewRedisScript("lock acquire", `
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return redis.call('INCR', KEYS[2])
+end
+return 0`)
+	lockScriptRenew = NewRedisScript("lock renew", `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0`)
+	lockScriptRelease = NewRedisScript("lock release", `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+)
+
+type LockOptions struct {
+	TTL      time.Duration // lease of the lock, it is renewed every TTL/3 while held
+	NoRenew  bool          // lock expires after TTL unless Unlock is invoked earlier
+	RetryMin time.Duration // first wait between attempts of Lock, doubled after each failure
+	RetryMax time.Duration // max wait between attempts of Lock
+}
+
+// NewLockOptions returns a copy of DefLockOptions to be modified
+func NewLockOptions() *LockOptions {
+	opts := DefLockOptions
+	return &opts
+}
+
+func lockOptions(opts []*LockOptions) *LockOptions {
+	if len(opts) > 0 && opts[0] != nil {
+		return opts[0]
+	}
+	return NewLockOptions()
+}
+
+var DefLockOptions = LockOptions{
+	TTL:      10 * time.Second,
+	RetryMin: 10 * time.Millisecond,
+	RetryMax: 500 * time.Millisecond,
+}
+
+// lockScripter runs the lock scripts, it is *Redis except in tests
+type lockScripter interface {
+	Script(cmd int, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// RedisLock is a lease on a named lock, Token increases every time the lock is acquired by anyone,
+// so storage written under the lock can reject writes carrying an older token
+type RedisLock struct {
+	redis    lockScripter
+	name     string
+	owner    string
+	token    int64
+	opts     *LockOptions
+	lost     chan struct{}
+	unlockCh chan struct{}
+	once     sync.Once
+	released int32
+}
+
+func lockKeys(name string) []string {
+	return []string{"lock:{" + name + "}", "lock:{" + name + "}:fence"}
+}
+
+func (r *RedisLock) Name() string {
+	return r.name
+}
+
+// Token returns the fencing token of this lease
+func (r *RedisLock) Token() int64 {
+	return r.token
+}
+
+// Lost is closed when the lease could not be renewed, work under the lock should be aborted
+func (r *RedisLock) Lost() <-chan struct{} {
+	return r.lost
+}
+
+func (r *RedisLock) markLost() {
+	r.once.Do(func() {
+		Warnf("redis lock:%s token:%d lost", r.name, r.token)
+		close(r.lost)
+	})
+}
+
+// Unlock releases the lock if it is still owned, ErrLockLost is returned if the lease expired
+func (r *RedisLock) Unlock() error {
+	if !atomic.CompareAndSwapInt32(&r.released, 0, 1) {
+		return nil
+	}
+	close(r.unlockCh)
+	re, err := r.redis.Script(lockScriptRelease, r.keys()[:1], r.owner)
+	if err != nil {
+		return err
+	}
+	if n, _ := re.(int64); n == 0 {
+		r.markLost()
+		return ErrLockLost
+	}
+	return nil
+}
+
+func (r *RedisLock) keys() []string {
+	return lockKeys(r.name)
+}
+
+// renew extends the lease every TTL/3, lastOK is when the lease was last set on redis
+func (r *RedisLock) renew(lastOK time.Time, stopCh chan struct{}) {
+	ticker := time.NewTicker(r.opts.TTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			// nobody renews the lease anymore
+			if atomic.LoadInt32(&r.released) == 0 {
+				r.markLost()
+			}
+			return
+		case <-r.unlockCh:
+			return
+		case <-ticker.C:
+			sent := time.Now()
+			re, err := r.redis.Script(lockScriptRenew, r.keys()[:1], r.owner, int64(r.opts.TTL/time.Millisecond))
+			if err != nil {
+				Warnf("redis lock:%s renew err:%v", r.name, err)
+				// retried until the lease may have run out on redis side
+				if time.Since(lastOK) >= r.opts.TTL {
+					r.markLost()
+					return
+				}
+				continue
+			}
+			if n, _ := re.(int64); n == 0 {
+				r.markLost()
+				return
+			}
+			lastOK = sent
+		}
+	}
+}
+
+// TryLock acquires the named lock once, ErrLockNotAcquired is returned if it is held by others
+func (r *Redis) TryLock(name string, opts ...*LockOptions) (*RedisLock, error) {
+	app := DefApp
+	if r.manager != nil && r.manager.app != nil {
+		app = r.manager.app
+	}
+	return tryLock(r, app, name, lockOptions(opts))
+}
+
+func tryLock(s lockScripter, app *App, name string, opt *LockOptions) (*RedisLock, error) {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	owner := hex.EncodeToString(buf)
+	sent := time.Now()
+	re, err := s.Script(lockScriptAcquire, lockKeys(name), owner, int64(opt.TTL/time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	token, _ := re.(int64)
+	if token == 0 {
+		return nil, ErrLockNotAcquired
+	}
+	lock := &RedisLock{
+		redis:    s,
+		name:     name,
+		owner:    owner,
+		token:    token,
+		opts:     opt,
+		lost:     make(chan struct{}),
+		unlockCh: make(chan struct{}),
+	}
+	if !opt.NoRenew && opt.TTL >= 3*time.Millisecond {
+		if !app.Go2(func(stopCh chan struct{}) { lock.renew(sent, stopCh) }) {
+			lock.markLost()
+		}
+	}
+	return lock, nil
+}
+
+// Lock retries TryLock with exponential backoff until acquired or ctx is done
+func (r *Redis) Lock(ctx context.Context, name string, opts ...*LockOptions) (*RedisLock, error) {
+	opt := lockOptions(opts)
+	wait := opt.RetryMin
+	if wait <= 0 {
+		wait = time.Millisecond
+	}
+	for {
+		lock, err := r.TryLock(name, opt)
+		if err != ErrLockNotAcquired {
+			return lock, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockBackoff(wait)):
+		}
+		if wait *= 2; opt.RetryMax > 0 && wait > opt.RetryMax {
+			wait = opt.RetryMax
+		}
+	}
+}
+
+// lockBackoff returns a random wait in [d/2, d) so waiting servers don't retry together
+func lockBackoff(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(d/2)))
+	if err != nil {
+		return d
+	}
+	return d/2 + time.Duration(n.Int64())
+}
+
+// TryLock acquires the lock on the global redis
+func (r *RedisManager) TryLock(name string, opts ...*LockOptions) (*RedisLock, error) {
+	return r.GetGlobal().TryLock(name, opts...)
+}
+
+func (r *RedisManager) Lock(ctx context.Context, name string, opts ...*LockOptions) (*RedisLock, error) {
+	return r.GetGlobal().Lock(ctx, name, opts...)
+}
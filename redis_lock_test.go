@@ -0,0 +1,106 @@
+package sugar
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_LockBackoff(t *testing.T) {
+	for _, d := range []time.Duration{0, 1, 2, 3, 10 * time.Millisecond, 500 * time.Millisecond} {
+		for i := 0; i < 100; i++ {
+			w := lockBackoff(d)
+			if d <= 1 && w != d || d > 1 && (w < d/2 || w >= d) {
+				t.Fatalf("backoff of %v is %v", d, w)
+			}
+		}
+	}
+}
+
+// fakeLockScripter acquires with token 1 and answers renew by renew
+type fakeLockScripter struct {
+	sync.Mutex
+	renew   func() (interface{}, error)
+	renews  int
+	release int64
+}
+
+func (r *fakeLockScripter) Script(cmd int, keys []string, args ...interface{}) (interface{}, error) {
+	r.Lock()
+	defer r.Unlock()
+	switch cmd {
+	case lockScriptAcquire:
+		return int64(1), nil
+	case lockScriptRenew:
+		r.renews++
+		return r.renew()
+	}
+	return r.release, nil
+}
+
+func (r *fakeLockScripter) renewCount() int {
+	r.Lock()
+	defer r.Unlock()
+	return r.renews
+}
+
+func isLost(lock *RedisLock, wait time.Duration) bool {
+	select {
+	case <-lock.Lost():
+		return true
+	case <-time.After(wait):
+		return false
+	}
+}
+
+func Test_RedisLockRenew(t *testing.T) {
+	app := NewApp()
+	opt := NewLockOptions()
+	opt.TTL = 60 * time.Millisecond
+
+	// the lease is kept while renewals succeed and unlock is not a loss
+	s := &fakeLockScripter{renew: func() (interface{}, error) { return int64(1), nil }, release: 1}
+	lock, err := tryLock(s, app, "ok", opt)
+	if err != nil || lock.Token() != 1 {
+		t.Fatal(lock, err)
+	}
+	if isLost(lock, 3*opt.TTL) || s.renewCount() < 3 {
+		t.Fatalf("lost with %d renewals", s.renewCount())
+	}
+	if err := lock.Unlock(); err != nil || isLost(lock, opt.TTL) {
+		t.Fatal("lost after unlock", err)
+	}
+
+	// renew errors are retried until the lease may have expired
+	s = &fakeLockScripter{renew: func() (interface{}, error) { return nil, errors.New("down") }}
+	start := time.Now()
+	lock, _ = tryLock(s, app, "err", opt)
+	if !isLost(lock, 3*opt.TTL) || time.Since(start) < opt.TTL || s.renewCount() < 2 {
+		t.Fatalf("lost after %v with %d renewals", time.Since(start), s.renewCount())
+	}
+
+	// the lease is owned by others
+	s = &fakeLockScripter{renew: func() (interface{}, error) { return int64(0), nil }}
+	lock, _ = tryLock(s, app, "gone", opt)
+	if !isLost(lock, opt.TTL) || s.renewCount() != 1 {
+		t.Fatalf("not lost with %d renewals", s.renewCount())
+	}
+	if err := lock.Unlock(); err != ErrLockLost {
+		t.Fatal(err)
+	}
+
+	// nobody renews after the app stops
+	s = &fakeLockScripter{renew: func() (interface{}, error) { return int64(1), nil }}
+	lock, _ = tryLock(s, app, "stop", opt)
+	app.Stop()
+	if !isLost(lock, opt.TTL) {
+		t.Fatal("not lost after app stop")
+	}
+	lock, _ = tryLock(s, app, "stopped", opt)
+	select {
+	case <-lock.Lost():
+	default:
+		t.Fatal("renew started on stopped app")
+	}
+}
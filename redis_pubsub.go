@@ -0,0 +1,216 @@
+package sugar
+
+import (
+	"net"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/golang/protobuf/proto"
+)
+
+var DefRedisResubscribeWait = time.Second // wait before resubscribing after a pubsub error
+
+// Sub replaces channels subscribed by the previous Sub with channels handled by fun,
+// channels subscribed by Subscribe since then are kept
+func (r *RedisManager) Sub(fun func(channel, data string), channels ...string) {
+	if fun == nil {
+		channels = nil
+	}
+	r.subLock.Lock()
+	defer r.subLock.Unlock()
+	r.unsubscribe(r.handlers, exceptNames(r.channels, channels), (*redis.PubSub).Unsubscribe)
+	r.channels = append([]string(nil), channels...)
+	if fun == nil {
+		return
+	}
+	r.subscribe(r.handlers, fun, channels, (*redis.PubSub).Subscribe)
+}
+
+// Subscribe handles messages of channels by fun, handlers of the same channels are replaced
+func (r *RedisManager) Subscribe(fun func(channel, data string), channels ...string) {
+	if fun == nil {
+		return
+	}
+	r.subLock.Lock()
+	defer r.subLock.Unlock()
+	r.channels = exceptNames(r.channels, channels) // not owned by Sub anymore
+	r.subscribe(r.handlers, fun, channels, (*redis.PubSub).Subscribe)
+}
+
+// PSubscribe handles messages of channels matching patterns by fun, channel is the matched one
+func (r *RedisManager) PSubscribe(fun func(channel, data string), patterns ...string) {
+	if fun == nil {
+		return
+	}
+	r.subLock.Lock()
+	defer r.subLock.Unlock()
+	r.subscribe(r.patterns, fun, patterns, (*redis.PubSub).PSubscribe)
+}
+
+func (r *RedisManager) Unsubscribe(channels ...string) {
+	r.subLock.Lock()
+	defer r.subLock.Unlock()
+	r.channels = exceptNames(r.channels, channels)
+	r.unsubscribe(r.handlers, channels, (*redis.PubSub).Unsubscribe)
+}
+
+func (r *RedisManager) PUnsubscribe(patterns ...string) {
+	r.subLock.Lock()
+	defer r.subLock.Unlock()
+	r.unsubscribe(r.patterns, patterns, (*redis.PubSub).PUnsubscribe)
+}
+
+// subscribe registers fun of names in m and subscribes them on every deployment, subLock should be held
+func (r *RedisManager) subscribe(m map[string]func(channel, data string), fun func(channel, data string), names []string, sub func(*redis.PubSub, ...string) error) {
+	if len(names) == 0 {
+		return
+	}
+	for _, v := range names {
+		m[v] = fun
+	}
+	for _, v := range r.subMap {
+		if v.pubSub == nil {
+			r.listen(v)
+		} else if err := sub(v.pubSub, names...); err != nil {
+			Warnf("redis %v subscribe %v failed, err:%v", v.conf, names, err) // subscribed again on reconnect
+		}
+	}
+}
+
+// unsubscribe is the reverse of subscribe, subLock should be held
+func (r *RedisManager) unsubscribe(m map[string]func(channel, data string), names []string, unsub func(*redis.PubSub, ...string) error) {
+	if len(names) == 0 {
+		return
+	}
+	for _, v := range names {
+		delete(m, v)
+	}
+	for _, v := range r.subMap {
+		if v.pubSub != nil {
+			unsub(v.pubSub, names...)
+		}
+	}
+}
+
+// listen creates the pubsub of re subscribing all channels and patterns, subLock should be held
+func (r *RedisManager) listen(re *Redis) {
+	select {
+	case <-r.done:
+		return
+	default:
+	}
+	pubSub := re.Subscribe()
+	if channels := subNames(r.handlers); len(channels) > 0 {
+		pubSub.Subscribe(channels...)
+	}
+	if patterns := subNames(r.patterns); len(patterns) > 0 {
+		pubSub.PSubscribe(patterns...)
+	}
+	re.pubSub = pubSub
+	r.app.goForRedis(func() {
+		for r.app.IsRunning() {
+			msg, err := pubSub.ReceiveMessage()
+			if err == nil {
+				r.dispatch(msg)
+				continue
+			}
+			// broken connections are redialed by pubsub itself, the wait avoids spinning while redis is down
+			select {
+			case <-r.done:
+				return
+			case <-time.After(DefRedisResubscribeWait):
+			}
+			if _, ok := err.(net.Error); ok {
+				continue
+			}
+			Warnf("redis %v pubsub err:%v, resubscribe", re.conf, err)
+			r.subLock.Lock()
+			if re.pubSub == pubSub {
+				pubSub.Close()
+				r.listen(re)
+			}
+			r.subLock.Unlock()
+			return
+		}
+	})
+}
+
+func (r *RedisManager) dispatch(msg *redis.Message) {
+	r.subLock.Lock()
+	var fun func(channel, data string)
+	if msg.Pattern != "" {
+		fun = r.patterns[msg.Pattern]
+	} else {
+		fun = r.handlers[msg.Channel]
+	}
+	r.subLock.Unlock()
+	if fun != nil {
+		r.app.Go(func() { fun(msg.Channel, msg.Payload) })
+	}
+}
+
+// exceptNames returns names not in except
+func exceptNames(names []string, except []string) []string {
+	var re []string
+	for _, v := range names {
+		found := false
+		for _, e := range except {
+			if v == e {
+				found = true
+				break
+			}
+		}
+		if !found {
+			re = append(re, v)
+		}
+	}
+	return re
+}
+
+func subNames(m map[string]func(channel, data string)) []string {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	return names
+}
+
+// PublishPb publishes v encoded by protobuf
+func (r *Redis) PublishPb(channel string, v proto.Message) error {
+	return r.Publish(channel, PbStr(v)).Err()
+}
+
+// PublishDB publishes v encoded by msgpack like DBStr
+func (r *Redis) PublishDB(channel string, v proto.Message) error {
+	return r.Publish(channel, DBStr(v)).Err()
+}
+
+// PublishPb publishes on the global redis
+func (r *RedisManager) PublishPb(channel string, v proto.Message) error {
+	return r.GetGlobal().PublishPb(channel, v)
+}
+
+func (r *RedisManager) PublishDB(channel string, v proto.Message) error {
+	return r.GetGlobal().PublishDB(channel, v)
+}
+
+// SubscribePb handles messages of PublishPb by fun with v created by newMsg, undecodable messages are dropped
+func (r *RedisManager) SubscribePb(newMsg func() proto.Message, fun func(channel string, v proto.Message), channels ...string) {
+	r.Subscribe(typedHandler(newMsg, fun, ParsePbStr), channels...)
+}
+
+// SubscribeDB handles messages of PublishDB like SubscribePb
+func (r *RedisManager) SubscribeDB(newMsg func() proto.Message, fun func(channel string, v proto.Message), channels ...string) {
+	r.Subscribe(typedHandler(newMsg, fun, ParseDBStr), channels...)
+}
+
+func typedHandler(newMsg func() proto.Message, fun func(channel string, v proto.Message), parse func(string, proto.Message) bool) func(channel, data string) {
+	return func(channel, data string) {
+		v := newMsg()
+		if !parse(data, v) {
+			Warnf("redis channel:%v drop undecodable message", channel)
+			return
+		}
+		fun(channel, v)
+	}
+}
@@ -0,0 +1,82 @@
+package sugar
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/golang/protobuf/proto"
+)
+
+func newTestRedisManager() *RedisManager {
+	return &RedisManager{
+		subMap:   map[string]*Redis{},
+		dbs:      map[int]*Redis{},
+		handlers: map[string]func(channel, data string){},
+		patterns: map[string]func(channel, data string){},
+		done:     make(chan struct{}),
+		app:      DefApp,
+	}
+}
+
+func recvPubSub(t *testing.T, ch chan string, want string) {
+	select {
+	case got := <-ch:
+		if got != want {
+			t.Fatalf("got %s want %s", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("%s not received", want)
+	}
+}
+
+func Test_RedisDispatch(t *testing.T) {
+	r := newTestRedisManager()
+	ch := make(chan string, 4)
+	r.Subscribe(func(channel, data string) { ch <- "channel " + channel + " " + data }, "room.1")
+	r.PSubscribe(func(channel, data string) { ch <- "pattern " + channel + " " + data }, "room.*")
+
+	// redis delivers a message of both to each subscription, routed by whether it carries the pattern
+	r.dispatch(&redis.Message{Channel: "room.1", Payload: "a"})
+	recvPubSub(t, ch, "channel room.1 a")
+	r.dispatch(&redis.Message{Channel: "room.1", Pattern: "room.*", Payload: "b"})
+	recvPubSub(t, ch, "pattern room.1 b")
+	r.dispatch(&redis.Message{Channel: "room.2", Payload: "c"})
+	r.dispatch(&redis.Message{Channel: "room.2", Pattern: "other.*", Payload: "d"})
+	select {
+	case got := <-ch:
+		t.Fatalf("unexpected %s", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func Test_RedisSub(t *testing.T) {
+	r := newTestRedisManager()
+	ch := make(chan string, 4)
+	sub := func(channel, data string) { ch <- "sub " + channel }
+	r.Sub(sub, "a", "b")
+	r.Subscribe(func(channel, data string) { ch <- "own " + channel }, "b")
+	r.Sub(sub, "a", "c")
+	if len(r.handlers) != 3 || len(r.channels) != 2 {
+		t.Fatalf("handlers %v channels %v", subNames(r.handlers), r.channels)
+	}
+	// b was taken over by Subscribe and is kept with its handler
+	r.dispatch(&redis.Message{Channel: "b"})
+	recvPubSub(t, ch, "own b")
+	r.Sub(sub)
+	if len(r.handlers) != 1 || r.handlers["b"] == nil {
+		t.Fatalf("handlers %v", subNames(r.handlers))
+	}
+}
+
+func Test_RedisTypedHandler(t *testing.T) {
+	var got []string
+	fun := typedHandler(func() proto.Message { return &scriptTestMsg{} }, func(channel string, v proto.Message) {
+		got = append(got, channel+" "+v.(*scriptTestMsg).Name)
+	}, ParseDBStr)
+	fun("bad", "\xc1")
+	fun("good", DBStr(&scriptTestMsg{Name: "x"}))
+	if len(got) != 1 || got[0] != "good x" {
+		t.Fatalf("got %v", got)
+	}
+}
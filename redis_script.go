@@ -0,0 +1,220 @@
+package sugar
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis"
+)
+
+type redisScript struct {
+	commit string
+	src    string
+	hash   string // sha1 of src, same as SCRIPT LOAD returns
+	file   string // path of the script loaded from directory
+}
+
+var (
+	scriptLock       sync.RWMutex
+	scripts          = map[int]*redisScript{}
+	scriptNames      = map[string]int{}
+	scriptDirs       []string
+	scriptReloadOnce sync.Once
+)
+
+func scriptHash(src string) string {
+	sum := sha1.Sum([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
+
+func getScript(cmd int) *redisScript {
+	scriptLock.RLock()
+	defer scriptLock.RUnlock()
+	return scripts[cmd]
+}
+
+// NewRedisScript registers a script and returns its id for Redis.Script, commit is shown in logs
+func NewRedisScript(commit, str string) int {
+	scriptLock.Lock()
+	defer scriptLock.Unlock()
+	cmd := len(scripts) + 1
+	scripts[cmd] = &redisScript{commit: commit, src: str, hash: scriptHash(str)}
+	return cmd
+}
+
+// RedisScriptID returns the id of the script loaded by LoadRedisScripts, name is the path
+// relative to the directory without .lua, e.g. "bag/add" for dir/bag/add.lua
+func RedisScriptID(name string) (int, bool) {
+	scriptLock.RLock()
+	defer scriptLock.RUnlock()
+	cmd, ok := scriptNames[name]
+	return cmd, ok
+}
+
+// LoadRedisScripts registers all .lua files under dir and preloads them into redis of DefApp,
+// the files are read again when ReloadSignal received
+func LoadRedisScripts(dir string) error {
+	if err := loadScriptDir(dir); err != nil {
+		return err
+	}
+	scriptLock.Lock()
+	scriptDirs = append(scriptDirs, dir)
+	scriptLock.Unlock()
+	scriptReloadOnce.Do(func() {
+		OnReload(func() {
+			if err := ReloadRedisScripts(); err != nil {
+				Errorf("reload redis scripts failed, err:%v", err)
+			}
+		})
+	})
+	return DefApp.PreloadRedisScripts()
+}
+
+// ReloadRedisScripts reads the directories of LoadRedisScripts again, changed scripts keep their ids,
+// scripts whose files are removed stay registered
+func ReloadRedisScripts() error {
+	scriptLock.RLock()
+	dirs := append([]string{}, scriptDirs...)
+	scriptLock.RUnlock()
+	for _, dir := range dirs {
+		if err := loadScriptDir(dir); err != nil {
+			return err
+		}
+	}
+	return DefApp.PreloadRedisScripts()
+}
+
+func loadScriptDir(dir string) error {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".lua" {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(strings.TrimSuffix(rel, ".lua"))] = string(data)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	scriptLock.Lock()
+	defer scriptLock.Unlock()
+	for name, src := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name)+".lua")
+		if cmd, ok := scriptNames[name]; ok {
+			if script := scripts[cmd]; script.src != src {
+				Infof("redis script %v changed", path)
+				// replaced instead of modified so readers holding the old one are not raced
+				scripts[cmd] = &redisScript{commit: name, src: src, hash: scriptHash(src), file: path}
+			}
+			continue
+		}
+		cmd := len(scripts) + 1
+		scripts[cmd] = &redisScript{commit: name, src: src, hash: scriptHash(src), file: path}
+		scriptNames[name] = cmd
+	}
+	return nil
+}
+
+// PreloadScripts loads all registered scripts into redis, so EVALSHA does not meet NOSCRIPT
+func (r *Redis) PreloadScripts() error {
+	scriptLock.RLock()
+	list := make([]*redisScript, 0, len(scripts))
+	for _, v := range scripts {
+		list = append(list, v)
+	}
+	scriptLock.RUnlock()
+	if len(list) == 0 {
+		return nil
+	}
+	return r.ForEachMaster(func(client *redis.Client) error {
+		pipe := client.Pipeline()
+		for _, v := range list {
+			pipe.ScriptLoad(v.src)
+		}
+		_, err := pipe.Exec()
+		return err
+	})
+}
+
+// PreloadRedisScripts loads all registered scripts into every redis of the app
+func (r *App) PreloadRedisScripts() error {
+	r.redisLock.Lock()
+	managers := r.redisManagers
+	r.redisLock.Unlock()
+	for _, m := range managers {
+		m.lock.RLock()
+		dbs := make([]*Redis, 0, len(m.dbs))
+		for _, v := range m.dbs {
+			dbs = append(dbs, v)
+		}
+		m.lock.RUnlock()
+		for _, v := range dbs {
+			if err := v.PreloadScripts(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Redis) Script(cmd int, keys []string, args ...interface{}) (interface{}, error) {
+	script := getScript(cmd)
+	if script == nil {
+		return nil, ErrScriptNotFound
+	}
+	re, err := r.EvalSha(script.hash, keys, args...).Result()
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT ") {
+		Infof("try reload redis script %v", script.commit)
+		// the script is loaded on every master since EVALSHA of a cluster goes to the slot of keys
+		err = r.ForEachMaster(func(client *redis.Client) error {
+			return client.ScriptLoad(script.src).Err()
+		})
+		if err != nil {
+			return nil, err
+		}
+		re, err = r.EvalSha(script.hash, keys, args...).Result()
+	}
+	if err != nil {
+		return nil, scriptError(err)
+	}
+	return re, nil
+}
+
+// ScriptErrPrefix starts error replies carrying an Errorz id, e.g. redis.error_reply('ERRZ 23')
+const ScriptErrPrefix = "ERRZ "
+
+// scriptError converts error replies of ScriptErrPrefix to Errorz
+func scriptError(err error) error {
+	if !strings.HasPrefix(err.Error(), ScriptErrPrefix) {
+		return err
+	}
+	id, e := strconv.ParseUint(strings.TrimSpace(err.Error()[len(ScriptErrPrefix):]), 10, 16)
+	if e != nil {
+		return err
+	}
+	return GetError(uint16(id))
+}
+
+// ScriptByName runs the script loaded by LoadRedisScripts
+func (r *Redis) ScriptByName(name string, keys []string, args ...interface{}) (interface{}, error) {
+	cmd, ok := RedisScriptID(name)
+	if !ok {
+		return nil, ErrScriptNotFound
+	}
+	return r.Script(cmd, keys, args...)
+}
@@ -0,0 +1,96 @@
+package sugar
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_LoadRedisScripts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "bag"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "bag", "add.lua"), []byte("return 1"), 0644)
+	if err := LoadRedisScripts(dir); err != nil {
+		t.Fatal(err)
+	}
+	cmd, ok := RedisScriptID("bag/add")
+	if !ok || getScript(cmd).hash != "e0e1f9fabfc9d4800c877a703b823ac0578ff8db" {
+		t.Fatalf("script not loaded %v", ok)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "bag", "add.lua"), []byte("return 2"), 0644)
+	if err := ReloadRedisScripts(); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := RedisScriptID("bag/add"); id != cmd || getScript(cmd).src != "return 2" {
+		t.Fatal("script not reloaded")
+	}
+}
+
+func Test_ScriptResult(t *testing.T) {
+	var v struct {
+		Name  string `json:"name" msgpack:"name"`
+		Level int    `json:"level" msgpack:"level"`
+	}
+	if err := decodeScriptJSON(`{"name":"a","level":3}`, &v); err != nil || v.Name != "a" || v.Level != 3 {
+		t.Fatalf("json result %v %v", v, err)
+	}
+	// cmsgpack.pack({name='b', level=4})
+	packed := "\x82\xa4name\xa1b\xa5level\x04"
+	if err := decodeScriptMsgpack(packed, &v); err != nil || v.Name != "b" || v.Level != 4 {
+		t.Fatalf("msgpack result %v %v", v, err)
+	}
+	// the msgpack integer 91 is '[', it must not be taken as JSON
+	if err := decodeScriptMsgpack("\x5b", &v); err != ErrDBDataType {
+		t.Fatalf("bad msgpack result %v", err)
+	}
+	if err := decodeScriptJSON(packed, &v); err != ErrDBDataType {
+		t.Fatalf("bad json result %v", err)
+	}
+	var list []string
+	if err := decodeScriptJSON([]interface{}{"x", "y"}, &list); err != nil || len(list) != 2 {
+		t.Fatalf("array result %v %v", list, err)
+	}
+	list = nil
+	if err := decodeScriptMsgpack([]interface{}{"x", "y"}, &list); err != nil || len(list) != 2 {
+		t.Fatalf("array result %v %v", list, err)
+	}
+
+	var m scriptTestMsg
+	if err := decodeScriptJSON(`{"name":"c"}`, &m); err != nil || m.Name != "c" {
+		t.Fatalf("json proto result %v %v", m, err)
+	}
+	m = scriptTestMsg{}
+	if err := decodeScriptMsgpack(DBStr(&scriptTestMsg{Name: "d"}), &m); err != nil || m.Name != "d" {
+		t.Fatalf("msgpack proto result %v %v", m, err)
+	}
+
+	// ScriptInto takes proto messages as msgpack and others as JSON
+	if err := decodeScriptResult(DBStr(&scriptTestMsg{Name: "e"}), &m); err != nil || m.Name != "e" {
+		t.Fatalf("proto result %v %v", m, err)
+	}
+	if err := decodeScriptResult(`{"name":"f","level":5}`, &v); err != nil || v.Name != "f" || v.Level != 5 {
+		t.Fatalf("json result %v %v", v, err)
+	}
+	if err := decodeScriptResult(packed, &v); err != ErrDBDataType {
+		t.Fatalf("msgpack result without proto %v", err)
+	}
+	if scriptError(errors.New(ScriptErrPrefix+"23")) != ErrLockNotAcquired {
+		t.Fatal("errorz reply not converted")
+	}
+}
+
+// scriptTestMsg is laid out as protoc-gen-go generates
+type scriptTestMsg struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+}
+
+func (m *scriptTestMsg) Reset()         { *m = scriptTestMsg{} }
+func (m *scriptTestMsg) String() string { return m.Name }
+func (*scriptTestMsg) ProtoMessage()    {}
@@ -0,0 +1,189 @@
+package sugar
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-redis/redis"
+)
+
+type shardNode struct {
+	hash uint32
+	rid  int
+}
+
+// RedisShard maps keys to redis instances of a RedisManager by consistent hashing,
+// only keys of about 1/n move when the n-th node is added
+type RedisShard struct {
+	manager  *RedisManager
+	replicas int // virtual nodes per redis
+	ring     []shardNode
+	rids     []int
+	lock     sync.RWMutex
+}
+
+// NewShard creates a shard over redis ids added by Add, replicas <= 0 means DefShardReplicas
+func (r *RedisManager) NewShard(replicas int, rids ...int) *RedisShard {
+	if replicas <= 0 {
+		replicas = DefShardReplicas
+	}
+	shard := &RedisShard{manager: r, replicas: replicas}
+	for _, rid := range rids {
+		shard.AddNode(rid)
+	}
+	return shard
+}
+
+// shardHashKey returns the part of key used for hashing, only the content of the first non-empty {...}
+// is hashed if present, so keys like user:{1001}:bag and user:{1001}:mail are stored together
+func shardHashKey(key string) string {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			return key[s+1 : s+1+e]
+		}
+	}
+	return key
+}
+
+func shardHash(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+func (r *RedisShard) AddNode(rid int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, v := range r.rids {
+		if v == rid {
+			return
+		}
+	}
+	r.rids = append(r.rids, rid)
+	for i := 0; i < r.replicas; i++ {
+		r.ring = append(r.ring, shardNode{hash: shardHash(strconv.Itoa(rid) + "#" + strconv.Itoa(i)), rid: rid})
+	}
+	sort.Slice(r.ring, func(i, j int) bool {
+		if r.ring[i].hash == r.ring[j].hash {
+			return r.ring[i].rid < r.ring[j].rid
+		}
+		return r.ring[i].hash < r.ring[j].hash
+	})
+}
+
+func (r *RedisShard) RemoveNode(rid int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	rids := r.rids[:0]
+	for _, v := range r.rids {
+		if v != rid {
+			rids = append(rids, v)
+		}
+	}
+	r.rids = rids
+	ring := r.ring[:0]
+	for _, v := range r.ring {
+		if v.rid != rid {
+			ring = append(ring, v)
+		}
+	}
+	r.ring = ring
+}
+
+// Nodes returns redis ids of the shard
+func (r *RedisShard) Nodes() []int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return append([]int{}, r.rids...)
+}
+
+// Locate returns the redis id which key belongs to, -1 if the shard is empty
+func (r *RedisShard) Locate(key string) int {
+	h := shardHash(shardHashKey(key))
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if len(r.ring) == 0 {
+		return -1
+	}
+	i := sort.Search(len(r.ring), func(i int) bool { return r.ring[i].hash >= h })
+	if i == len(r.ring) {
+		i = 0
+	}
+	return r.ring[i].rid
+}
+
+// Get returns the redis which key belongs to
+func (r *RedisShard) Get(key string) *Redis {
+	return r.manager.GetByRid(r.Locate(key))
+}
+
+// Migrate scans keys matching pattern on every node and moves those located on another node now,
+// it should be invoked after AddNode or RemoveNode while writes to the moving keys are paused.
+// rids defaults to current nodes, pass the id of a removed node to drain it.
+// ttl of keys is kept, the number of moved keys is returned
+func (r *RedisShard) Migrate(match string, batch int64, rids ...int) (int, error) {
+	if len(rids) == 0 {
+		rids = r.Nodes()
+	}
+	var moved int64
+	for _, rid := range rids {
+		src := r.manager.GetByRid(rid)
+		if src == nil {
+			continue
+		}
+		// keys of a cluster are scanned on each master
+		err := src.ForEachMaster(func(client *redis.Client) error {
+			var cursor uint64
+			for {
+				keys, next, err := client.Scan(cursor, match, batch).Result()
+				if err != nil {
+					return err
+				}
+				for _, key := range keys {
+					to := r.Locate(key)
+					if to == rid || to < 0 {
+						continue
+					}
+					if err := r.move(src, r.manager.GetByRid(to), key); err != nil {
+						return err
+					}
+					atomic.AddInt64(&moved, 1)
+				}
+				if cursor = next; cursor == 0 {
+					return nil
+				}
+			}
+		})
+		if err != nil {
+			return int(atomic.LoadInt64(&moved)), err
+		}
+	}
+	return int(moved), nil
+}
+
+func (r *RedisShard) move(src, dst *Redis, key string) error {
+	if dst == nil {
+		return ErrShardNodeNotFound
+	}
+	data, err := src.Dump(key).Result()
+	if RedisError(err) {
+		return err
+	} else if err != nil {
+		return nil // deleted during scan
+	}
+	ttl, err := src.PTTL(key).Result()
+	if err != nil {
+		return err
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	if err := dst.RestoreReplace(key, ttl, data).Err(); err != nil {
+		return err
+	}
+	return src.Del(key).Err()
+}
+
+var DefShardReplicas = 160 // virtual nodes per redis
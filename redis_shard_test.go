@@ -0,0 +1,44 @@
+package sugar
+
+import (
+	"strconv"
+	"testing"
+)
+
+func Test_RedisShard(t *testing.T) {
+	shard := (&RedisManager{}).NewShard(0, 1, 2, 3)
+	const cnt = 30000
+	before := make([]int, cnt)
+	count := map[int]int{}
+	for i := range before {
+		before[i] = shard.Locate("player:" + strconv.Itoa(i))
+		count[before[i]]++
+	}
+	for rid, n := range count {
+		if n < cnt/3*7/10 || n > cnt/3*13/10 {
+			t.Fatalf("unbalanced node:%d keys:%d", rid, n)
+		}
+	}
+
+	shard.AddNode(4)
+	moved := 0
+	for i, rid := range before {
+		if now := shard.Locate("player:" + strconv.Itoa(i)); now != rid {
+			if now != 4 {
+				t.Fatalf("key moved between old nodes %d -> %d", rid, now)
+			}
+			moved++
+		}
+	}
+	if moved < cnt/4*7/10 || moved > cnt/4*13/10 {
+		t.Fatalf("moved %d keys", moved)
+	}
+
+	if shard.Locate("user:{1001}:bag") != shard.Locate("user:{1001}:mail") || shardHashKey("a{}b") != "a{}b" {
+		t.Fatal("hash tag not used")
+	}
+	shard.RemoveNode(4)
+	if shard.Locate("player:1") != before[1] || len(shard.Nodes()) != 3 {
+		t.Fatal("remove node should restore placement")
+	}
+}
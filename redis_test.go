@@ -0,0 +1,33 @@
+package sugar
+
+import (
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+func Test_RedisConfig(t *testing.T) {
+	cases := []struct {
+		conf   RedisConfig
+		mode   int
+		str    string
+		client bool
+	}{
+		{RedisConfig{Addr: "127.0.0.1:6379"}, RedisStandalone, "127.0.0.1:6379", true},
+		{RedisConfig{Addr: "a:26379", MasterName: "m"}, RedisSentinel, "sentinel:m@a:26379", true},
+		{RedisConfig{MasterName: "m", Addrs: []string{"a:26379", "b:26379"}, Cluster: true}, RedisSentinel, "sentinel:m@a:26379,b:26379", true},
+		{RedisConfig{Addr: "a:7000", Cluster: true}, RedisCluster, "cluster:a:7000", false},
+		{RedisConfig{Addrs: []string{"a:7000", "b:7000"}, Cluster: true}, RedisCluster, "cluster:a:7000,b:7000", false},
+	}
+	for _, c := range cases {
+		if c.conf.Mode() != c.mode || c.conf.String() != c.str {
+			t.Fatalf("%+v mode %d string %s", c.conf, c.conf.Mode(), c.conf.String())
+		}
+		re := newRedis(&c.conf, nil)
+		_, cluster := re.UniversalClient.(*redis.ClusterClient)
+		if (re.Client != nil) != c.client || cluster == c.client {
+			t.Fatalf("%+v client %T", c.conf, re.UniversalClient)
+		}
+		re.Close()
+	}
+}
@@ -1,6 +1,9 @@
 package sugar
 
-import "time"
+import (
+	"sync/atomic"
+	"time"
+)
 
 type Stat struct {
 	GoCount     int
@@ -8,14 +11,26 @@ type Stat struct {
 	StartTime   time.Time
 	LastPanic   int
 	PanicCount  int32
+
+	RejectConnCount    int32 // rejected by MaxConn
+	RejectIPCount      int32 // rejected by MaxConnPerIP
+	RejectRateCount    int32 // rejected by MaxAcceptPerSecond
+	RejectFilterCount  int32 // rejected by cidr lists or accept filter
+	RejectPendingCount int32 // rejected by MaxUDPPendingSession
+	SlowConsumerCount  int32 // reported slow consumers
+	UDPDropCount       int32 // udp datagrams dropped by a full send batch or failed to send
 }
 
 func GetStat() *Stat {
-	stat.GoCount = int(goCount)
-	stat.MsgQueCount = len(msgQueMap)
-	return &stat
+	return DefApp.GetStat()
+}
+
+func (r *App) GetStat() *Stat {
+	r.stat.GoCount = int(atomic.LoadInt64(&r.goCount))
+	r.msgQueMapSync.Lock()
+	r.stat.MsgQueCount = len(r.msgQueMap)
+	r.msgQueMapSync.Unlock()
+	return &r.stat
 }
 
 var goID uint64
-var goCount int64 //number of goroutines
-var stat = Stat{}
@@ -1,132 +1,251 @@
 package sugar
 
 import (
+	"context"
 	"net"
 	"os"
-	"os/exec"
 	"os/signal"
-	"path/filepath"
 	"strings"
 	"sync/atomic"
 	"syscall"
-	"time"
 )
 
-func StartServer(addr string, typ MsgType, handler IMsgHandler, parser *Parser) error {
+func StartServer(addr string, typ MsgType, handler IMsgHandler, parser *Parser, opts ...*ConnOptions) error {
+	return DefApp.StartServer(addr, typ, handler, parser, opts...)
+}
+
+func (r *App) StartServer(addr string, typ MsgType, handler IMsgHandler, parser *Parser, opts ...*ConnOptions) error {
+	opt := connOptions(opts)
 	addrInfo := strings.Split(addr, "://")
 	if addrInfo[0] == "tcp" || addrInfo[0] == "all" {
-		listen, err := net.Listen("tcp", addrInfo[1])
-		if err == nil {
-			msgQue := newTCPListen(listen, typ, handler, parser, addr)
-			Go(func() {
-				cid := AddStopCheck("msgQue listen")
+		listeners, err := listenTCP(addrInfo[1])
+		if err != nil {
+			Errorf("listen on %s failed, err:%s", addr, err)
+			return err
+		}
+		for _, listen := range listeners {
+			msgQue := newTCPListen(r, listen, typ, handler, parser, addr, opt)
+			r.Go(func() {
+				cid := r.AddStopCheck("msgQue listen")
 				Debugf("process listen for msgQue:%d", msgQue.id)
 				msgQue.listen()
 				Debugf("process listen end for msgQue:%d", msgQue.id)
-				RemoveStopCheck(cid)
+				r.RemoveStopCheck(cid)
 			})
-		} else {
-			Errorf("listen on %s failed, err:%s", addr, err)
-			return err
 		}
 	}
 	if addrInfo[0] == "udp" || addrInfo[0] == "all" {
-		udpAddr, err := net.ResolveUDPAddr("udp", addrInfo[1])
+		conns, err := listenUDP(addrInfo[1])
 		if err != nil {
 			Errorf("listen on %s failed, err:%s", addr, err)
 			return err
 		}
-		conn, err := net.ListenUDP("udp", udpAddr)
-		if err == nil {
-			msgQue := newUDPListen(conn, typ, handler, parser, addr)
-			Go(func() {
+		for _, conn := range conns {
+			msgQue := newUDPListen(r, conn, typ, handler, parser, addr, opt)
+			if len(conns) > 1 {
+				msgQue.goCnt = UDPServerGoCnt / len(conns)
+				if msgQue.goCnt < 1 {
+					msgQue.goCnt = 1
+				}
+			}
+			r.Go(func() {
 				Debugf("process listen for msgQue:%d", msgQue.id)
 				msgQue.listen()
 				Debugf("process listen end for msgQue:%d", msgQue.id)
 			})
-		} else {
-			Errorf("listen on %s failed, err:%s", addr, err)
-			return err
 		}
 	}
 	return nil
 }
 
-func StartConnect(netType string, addr string, typ MsgType, handler IMsgHandler, parser *Parser, user interface{}) IMsgQue {
-	msgQue := newTCPConn(netType, addr, nil, typ, handler, parser, user)
-	if handler.OnNewMsgQue(msgQue) {
-		msgQue.Reconnect(0)
-		return msgQue
+// reusePortCnt returns the number of sockets to open for one address
+func reusePortCnt() int {
+	if ReusePortCnt > 1 && reusePortControl != nil {
+		return ReusePortCnt
 	}
-	msgQue.Stop()
-	return nil
+	return 1
 }
 
-func Daemon(skip ...string) {
-	if os.Getppid() != 1 {
-		filePath, _ := filepath.Abs(os.Args[0])
-		var newCmd []string
-		for _, v := range os.Args {
-			add := true
-			for _, s := range skip {
-				if strings.Contains(v, s) {
-					add = false
-					break
-				}
+func listenTCP(addr string) ([]net.Listener, error) {
+	listeners, err := bindTCP(addr)
+	for _, v := range listeners {
+		if tcp, ok := v.(*net.TCPListener); ok {
+			addUpgradeListener("tcp", addr, tcp)
+		}
+	}
+	return listeners, err
+}
+
+func bindTCP(addr string) ([]net.Listener, error) {
+	if listeners := inheritTCP(addr); len(listeners) > 0 {
+		return listeners, nil
+	}
+	cnt := reusePortCnt()
+	if cnt == 1 {
+		listen, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listen}, nil
+	}
+
+	lc := net.ListenConfig{Control: reusePortControl}
+	var listeners []net.Listener
+	for i := 0; i < cnt; i++ {
+		listen, err := lc.Listen(context.Background(), "tcp", addr)
+		if err != nil {
+			for _, v := range listeners {
+				v.Close()
 			}
-			if add {
-				newCmd = append(newCmd, v)
+			return nil, err
+		}
+		listeners = append(listeners, listen)
+	}
+	return listeners, nil
+}
+
+func listenUDP(addr string) ([]*net.UDPConn, error) {
+	conns, err := bindUDP(addr)
+	for _, v := range conns {
+		addUpgradeListener("udp", addr, v)
+	}
+	return conns, err
+}
+
+func bindUDP(addr string) ([]*net.UDPConn, error) {
+	if conns := inheritUDP(addr); len(conns) > 0 {
+		return conns, nil
+	}
+	cnt := reusePortCnt()
+	if cnt == 1 {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.UDPConn{conn}, nil
+	}
+
+	lc := net.ListenConfig{Control: reusePortControl}
+	var conns []*net.UDPConn
+	for i := 0; i < cnt; i++ {
+		conn, err := lc.ListenPacket(context.Background(), "udp", addr)
+		if err != nil {
+			for _, v := range conns {
+				v.Close()
 			}
+			return nil, err
 		}
-		cmd := exec.Command(filePath)
-		cmd.Args = newCmd
-		cmd.Start()
+		conns = append(conns, conn.(*net.UDPConn))
 	}
+	return conns, nil
+}
+
+func StartConnect(netType string, addr string, typ MsgType, handler IMsgHandler, parser *Parser, user interface{}, opts ...*ConnOptions) IMsgQue {
+	return DefApp.StartConnect(netType, addr, typ, handler, parser, user, opts...)
 }
 
+func (r *App) StartConnect(netType string, addr string, typ MsgType, handler IMsgHandler, parser *Parser, user interface{}, opts ...*ConnOptions) IMsgQue {
+	msgQue := newTCPConn(r, netType, addr, nil, typ, handler, parser, user, connOptions(opts))
+	if handler.OnNewMsgQue(msgQue) {
+		msgQue.Reconnect(0)
+		return msgQue
+	}
+	msgQue.Stop()
+	return nil
+}
+
+// ReusePortCnt is the number of SO_REUSEPORT sockets opened per address by StartServer,
+// the kernel balances connections and datagrams between them, only supported on linux
+var ReusePortCnt = 0
+
 func WaitForSystemExit(atexit ...func()) {
-	stat.StartTime = time.Now()
-	stopChan = make(chan os.Signal, 1)
+	DefApp.WaitForSystemExit(atexit...)
+}
+
+// WaitForSystemExit blocks until the app is stopped or a stop signal received, then cleans up
+func (r *App) WaitForSystemExit(atexit ...func()) {
+	r.stat.StartTime = Now()
+	stopChan := make(chan os.Signal, 8)
 	signal.Notify(stopChan, os.Interrupt, os.Kill, syscall.SIGTERM)
-	for stop == 0 {
+	if UpgradeSignal != nil {
+		signal.Notify(stopChan, UpgradeSignal)
+	}
+	notifyHookSignals(stopChan)
+	r.stopLock.Lock()
+	r.stopChan = stopChan
+	r.stopLock.Unlock()
+	upgradeReady()
+	for r.IsRunning() {
 		select {
-		case <-stopChan:
-			Stop()
+		case sig := <-stopChan:
+			if sig != nil && sig == UpgradeSignal {
+				if err := Upgrade(); err != nil {
+					Errorf("upgrade failed, err:%v", err)
+					continue
+				}
+				upgraded = true
+			} else if runSignalHooks(sig) {
+				continue
+			}
+			r.Stop()
 		}
 	}
-	Stop()
+	r.Stop()
 	for _, v := range atexit {
 		v()
 	}
-	for _, v := range redisManagers {
+	r.redisLock.Lock()
+	managers := r.redisManagers
+	r.redisLock.Unlock()
+	for _, v := range managers {
 		v.close()
 	}
-	waitAllForRedis.Wait()
+	r.waitAllForRedis.Wait()
+	if r == DefApp {
+		releasePidFile()
+	}
 }
 
 func Stop() {
-	if !atomic.CompareAndSwapInt32(&stop, 0, 1) {
+	DefApp.Stop()
+}
+
+// Stop drains and closes all connections and listeners of the app then waits all its goroutines
+func (r *App) Stop() {
+	if !atomic.CompareAndSwapInt32(&r.stop, 0, 1) {
 		return
 	}
 
-	for _, v := range msgQueMap {
+	for _, v := range r.MsgQues() {
 		v.Stop()
 	}
+	r.waitDrain(StopDrainTimeout)
 
 	// trigger routines final clean
-	notifyRoutinesClose()
+	r.notifyRoutinesClose()
 
-	stopChan <- nil
+	r.stopLock.Lock()
+	if r.stopChan != nil {
+		select {
+		case r.stopChan <- nil:
+		default:
+		}
+	}
+	r.stopLock.Unlock()
 
 	// wait all routines closed
-	for sc := 0; !waitAll.TryWait(); sc++ {
+	for sc := 0; !r.waitAll.TryWait(); sc++ {
 		Sleep(1)
 		if sc >= 3000 {
-			stopCheckMap.Lock()
-			for _, v := range stopCheckMap.M {
+			r.stopCheckMap.Lock()
+			for _, v := range r.stopCheckMap.M {
 				Errorf("Server Stop Timeout: %v", v)
 			}
-			stopCheckMap.Unlock()
+			r.stopCheckMap.Unlock()
 			sc = 0
 		}
 	}
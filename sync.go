@@ -23,6 +23,3 @@ func (r *WaitGroup) Wait() {
 func (r *WaitGroup) TryWait() bool {
 	return atomic.LoadInt64(&r.count) == 0
 }
-
-//wait all goroutines
-var waitAll = &WaitGroup{}
@@ -1,47 +1,76 @@
 package sugar
 
-import "time"
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 var StartTick int64
-var NowTick int64
-var Timestamp int64
+var NowTick int64   // ms of the clock, refreshed every ms, read it by GetNowTick
+var Timestamp int64 // seconds of the clock, read it by GetTimestamp
+var tickLock sync.Mutex
 
-func SetTimeout(interval int, fn func(...interface{}) int, args ...interface{}) {
+// GetNowTick returns NowTick, safe to be invoked while the clock is refreshed
+func GetNowTick() int64 {
+	return atomic.LoadInt64(&NowTick)
+}
+
+// GetTimestamp returns Timestamp, safe to be invoked while the clock is refreshed
+func GetTimestamp() int64 {
+	return atomic.LoadInt64(&Timestamp)
+}
+
+func SetTimeout(interval int, fn func(...interface{}) int, args ...interface{}) *Timer {
+	return DefApp.SetTimeout(interval, fn, args...)
+}
+
+// SetTimeout invokes fn after interval ms, then repeats with the interval fn returns until it returns 0 or app stops
+func (r *App) SetTimeout(interval int, fn func(...interface{}) int, args ...interface{}) *Timer {
 	if interval < 0 {
 		Errorf("invalid timeout interval:%v", interval)
-		return
+		return nil
 	}
 	Debugf("timeout  interval:%v", interval)
+	if interval == 0 {
+		return nil
+	}
+
+	var t *Timer
+	t = r.newTimer(0, func() {
+		if next := fn(args...); next > 0 {
+			t.Reset(next)
+		}
+	}, nil)
+	t.Reset(interval)
+	return t
+}
 
-	Go2(func(stopCh chan struct{}) {
-		var tick *time.Timer
-		for interval > 0 {
-			tick = time.NewTimer(time.Millisecond * time.Duration(interval))
+func timerTick() {
+	StartTick = Now().UnixNano() / 1000000
+	refreshTick()
+	// the clock is shared by all apps, timing wheels of other apps refresh it too after DefApp stops
+	DefApp.Go2(func(stopCh chan struct{}) {
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		for {
 			select {
 			case <-stopCh:
-				interval = 0
-			case <-tick.C:
-				tick.Stop()
-				interval = fn(args...)
+				return
+			case <-ticker.C:
+				refreshTick()
 			}
 		}
-		if tick != nil {
-			tick.Stop()
-		}
 	})
 }
 
-func timerTick() {
-	StartTick = time.Now().UnixNano() / 1000000
-	NowTick = StartTick
-	Timestamp = NowTick / 1000
-	Go(func() {
-		for IsRunning() {
-			Sleep(1)
-			NowTick = time.Now().UnixNano() / 1000000
-			Timestamp = NowTick / 1000
-		}
-	})
+// refreshTick is serialized so concurrent refreshes never store an older tick after a newer one
+func refreshTick() {
+	tickLock.Lock()
+	defer tickLock.Unlock()
+	tick := Now().UnixNano() / 1000000
+	atomic.StoreInt64(&NowTick, tick)
+	atomic.StoreInt64(&Timestamp, tick/1000)
 }
 
 const layout = "2006-01-02 15:04:05"
@@ -51,7 +80,7 @@ func ParseTime(str string) (time.Time, error) {
 }
 
 func Date() string {
-	return time.Now().Format(layout)
+	return Now().Format(layout)
 }
 
 func UnixTime(sec, nsec int64) time.Time {
@@ -59,11 +88,12 @@ func UnixTime(sec, nsec int64) time.Time {
 }
 
 func UnixMs() int64 {
-	return time.Now().UnixNano() / 1000000
+	return Now().UnixNano() / 1000000
 }
 
+// Now returns the time of current clock, see SetClock
 func Now() time.Time {
-	return time.Now()
+	return getClock().Now()
 }
 
 func Sleep(ms int) {
@@ -78,14 +108,19 @@ func GetNextHourIntervalMS(timestamp int64) int {
 	return GetNextHourIntervalS(timestamp) * 1000
 }
 
+// Deprecated: use time.Time.Hour with a location or GameCalendar
 func GetHour24(timestamp int64, timezone int) int {
 	hour := int((timestamp%86400)/3600) + timezone
 	if hour > 24 {
 		return hour - 24
 	}
+	if hour < 0 {
+		return hour + 24
+	}
 	return hour
 }
 
+// Deprecated: use time.Time.Hour with a location or GameCalendar
 func GetHour23(timestamp int64, timezone int) int {
 	hour := GetHour24(timestamp, timezone)
 	if hour == 24 {
@@ -94,16 +129,19 @@ func GetHour23(timestamp int64, timezone int) int {
 	return hour
 }
 
+// Deprecated: use time.Time.Hour with a location or GameCalendar
 func GetHour(timestamp int64, timezone int) int {
 	return GetHour23(timestamp, timezone)
 }
 
+// Deprecated: use GameCalendar.DayDiff
 func IsDiffDay(now, old int64, timezone int) int {
 	now += int64(timezone * 3600)
 	old += int64(timezone * 3600)
 	return int((now / 86400) - (old / 86400))
 }
 
+// Deprecated: use GameCalendar.SameDay with the reset hour as day start
 func IsDiffHour(now, old int64, hour, timezone int) bool {
 	diff := IsDiffDay(now, old, timezone)
 	if diff == 1 {
@@ -117,6 +155,7 @@ func IsDiffHour(now, old int64, hour, timezone int) bool {
 	return (GetHour23(now, timezone) >= hour) && (GetHour23(old, timezone) < hour)
 }
 
+// Deprecated: use GameCalendar.SameWeek
 func IsDiffWeek(now, old int64, hour, timezone int) bool {
 	diffHour := IsDiffHour(now, old, hour, timezone)
 	now += int64(timezone * 3600)
@@ -0,0 +1,279 @@
+package sugar
+
+import (
+	"sync"
+	"time"
+)
+
+// TimerDispatcher executes a timer callback, it can post fn to an actor or a worker goroutine
+type TimerDispatcher func(fn func())
+
+// TimerInline runs callbacks in the timing wheel goroutine, fn must return quickly
+var TimerInline TimerDispatcher = func(fn func()) { Try(fn, nil) }
+
+const (
+	wheelBits0  = 8 // level 0 has 256 slots of 1ms
+	wheelBits   = 6 // higher levels have 64 slots each
+	wheelLevels = 5
+	wheelMax    = int64(1) << (wheelBits0 + wheelBits*(wheelLevels-1)) // about 49 days, longer timers are cascaded again
+)
+
+// Timer is a handle of a timer scheduled on the timing wheel of an app
+type Timer struct {
+	wheel    *timerWheel
+	expire   int64 // NowTick to fire at
+	interval int64 // repeat interval in ms, 0 means fire once
+	fn       func()
+	dispatch TimerDispatcher
+	slot     *timerSlot
+	prev     *Timer
+	next     *Timer
+}
+
+// Cancel stops the timer, returns false if it already fired or was cancelled
+func (r *Timer) Cancel() bool {
+	r.wheel.lock.Lock()
+	defer r.wheel.lock.Unlock()
+	if r.slot == nil {
+		return false
+	}
+	r.slot.remove(r)
+	return true
+}
+
+// Reset reschedules the timer to fire after ms, it also rearms a fired or cancelled timer
+func (r *Timer) Reset(ms int) {
+	r.wheel.lock.Lock()
+	if r.slot != nil {
+		r.slot.remove(r)
+	}
+	r.expire = GetNowTick() + int64(ms)
+	r.wheel.add(r)
+	r.wheel.lock.Unlock()
+}
+
+type timerSlot struct {
+	head *Timer
+}
+
+func (r *timerSlot) push(t *Timer) {
+	t.slot = r
+	t.prev = nil
+	t.next = r.head
+	if r.head != nil {
+		r.head.prev = t
+	}
+	r.head = t
+}
+
+func (r *timerSlot) remove(t *Timer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		r.head = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.slot, t.prev, t.next = nil, nil, nil
+}
+
+// take detaches all timers of the slot
+func (r *timerSlot) take() []*Timer {
+	var list []*Timer
+	for t := r.head; t != nil; {
+		next := t.next
+		t.slot, t.prev, t.next = nil, nil, nil
+		list = append(list, t)
+		t = next
+	}
+	r.head = nil
+	return list
+}
+
+// timerWheel is a hierarchical timing wheel with millisecond resolution driven by NowTick
+type timerWheel struct {
+	app    *App
+	cur    int64 // next tick to process
+	levels [wheelLevels][]timerSlot
+	lock   sync.Mutex
+}
+
+func newTimerWheel(app *App, now int64) *timerWheel {
+	w := &timerWheel{app: app, cur: now}
+	w.levels[0] = make([]timerSlot, 1<<wheelBits0)
+	for i := 1; i < wheelLevels; i++ {
+		w.levels[i] = make([]timerSlot, 1<<wheelBits)
+	}
+	return w
+}
+
+func wheelShift(level int) uint {
+	if level == 0 {
+		return 0
+	}
+	return uint(wheelBits0 + wheelBits*(level-1))
+}
+
+// add must be invoked with lock held
+func (r *timerWheel) add(t *Timer) {
+	expire := t.expire
+	if expire < r.cur {
+		expire = r.cur
+	}
+	if expire-r.cur >= wheelMax {
+		expire = r.cur + wheelMax - 1
+	}
+	delta := expire - r.cur
+	for l := 0; l < wheelLevels; l++ {
+		if l == wheelLevels-1 || delta < int64(1)<<wheelShift(l+1) {
+			slots := r.levels[l]
+			slots[(expire>>wheelShift(l))&int64(len(slots)-1)].push(t)
+			return
+		}
+	}
+}
+
+// cascade moves timers of the current slot of level down to lower levels
+func (r *timerWheel) cascade(level int) {
+	slots := r.levels[level]
+	index := (r.cur >> wheelShift(level)) & int64(len(slots)-1)
+	for _, t := range slots[index].take() {
+		r.add(t)
+	}
+	if index == 0 && level+1 < wheelLevels {
+		r.cascade(level + 1)
+	}
+}
+
+// tick processes the current tick and appends due timers to fired, must be invoked with lock held
+func (r *timerWheel) tick(fired []*Timer) []*Timer {
+	index := r.cur & (1<<wheelBits0 - 1)
+	if index == 0 {
+		r.cascade(1)
+	}
+	for _, t := range r.levels[0][index].take() {
+		if t.expire > r.cur {
+			r.add(t) // clamped by wheelMax
+			continue
+		}
+		fired = append(fired, t)
+		if t.interval > 0 {
+			t.expire = r.cur + t.interval
+			r.add(t)
+		}
+	}
+	r.cur++
+	return fired
+}
+
+func (r *timerWheel) advance(now int64, fired []*Timer) []*Timer {
+	r.lock.Lock()
+	if now+1 < r.cur {
+		r.rebase(now)
+	}
+	for r.cur <= now {
+		index := r.cur & (1<<wheelBits0 - 1)
+		if index != 0 && r.levels[0][index].head == nil {
+			// skip empty slots until the next timer or cascade, a fast forwarded clock may jump days
+			next := r.cur - index + 1<<wheelBits0
+			for i := index + 1; i < 1<<wheelBits0; i++ {
+				if r.levels[0][i].head != nil {
+					next = r.cur - index + i
+					break
+				}
+			}
+			if next > now+1 {
+				next = now + 1
+			}
+			r.cur = next
+			continue
+		}
+		fired = r.tick(fired)
+	}
+	r.lock.Unlock()
+	return fired
+}
+
+// rebase moves the wheel back to now when the clock goes backwards, timers keep their remaining delay
+func (r *timerWheel) rebase(now int64) {
+	var list []*Timer
+	for l := range r.levels {
+		for i := range r.levels[l] {
+			list = append(list, r.levels[l][i].take()...)
+		}
+	}
+	shift := now + 1 - r.cur
+	r.cur = now + 1
+	for _, t := range list {
+		t.expire += shift
+		r.add(t)
+	}
+}
+
+func (r *timerWheel) run(stopCh chan struct{}) {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	var fired []*Timer
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			refreshTick()
+			fired = r.advance(GetNowTick(), fired[:0])
+			for i, t := range fired {
+				t.dispatch(t.fn)
+				fired[i] = nil
+			}
+		}
+	}
+}
+
+func (r *App) timerWheel() *timerWheel {
+	r.wheelOnce.Do(func() {
+		r.wheel = newTimerWheel(r, GetNowTick())
+		r.Go2(r.wheel.run)
+	})
+	return r.wheel
+}
+
+// AddTimer schedules fn after delay ms, repeats every interval ms if interval > 0,
+// fn is executed by dispatcher, nil means a new goroutine of the app. timers are dropped when app stops
+func (r *App) AddTimer(delay, interval int, fn func(), dispatcher TimerDispatcher) *Timer {
+	t := r.newTimer(interval, fn, dispatcher)
+	t.Reset(delay)
+	return t
+}
+
+func (r *App) newTimer(interval int, fn func(), dispatcher TimerDispatcher) *Timer {
+	if dispatcher == nil {
+		dispatcher = r.Go
+	}
+	if interval < 0 {
+		interval = 0
+	}
+	return &Timer{wheel: r.timerWheel(), interval: int64(interval), fn: fn, dispatch: dispatcher}
+}
+
+// AfterFunc invokes fn once after ms
+func (r *App) AfterFunc(ms int, fn func()) *Timer {
+	return r.AddTimer(ms, 0, fn, nil)
+}
+
+// Every invokes fn every ms until the timer is cancelled
+func (r *App) Every(ms int, fn func()) *Timer {
+	return r.AddTimer(ms, ms, fn, nil)
+}
+
+func AddTimer(delay, interval int, fn func(), dispatcher TimerDispatcher) *Timer {
+	return DefApp.AddTimer(delay, interval, fn, dispatcher)
+}
+
+func AfterFunc(ms int, fn func()) *Timer {
+	return DefApp.AfterFunc(ms, fn)
+}
+
+func Every(ms int, fn func()) *Timer {
+	return DefApp.Every(ms, fn)
+}
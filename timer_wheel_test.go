@@ -0,0 +1,56 @@
+package sugar
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_TimerWheelCascade(t *testing.T) {
+	w := newTimerWheel(nil, 1000)
+	fireAt := map[*Timer]int64{}
+	var timers []*Timer
+	for _, expire := range []int64{1000, 1255, 1256, 20000, 1 << 21, 1<<23 + 7} {
+		timer := &Timer{wheel: w, expire: expire}
+		w.add(timer)
+		timers = append(timers, timer)
+	}
+	cancelled := &Timer{wheel: w, expire: 5000}
+	w.add(cancelled)
+	if !cancelled.Cancel() || cancelled.Cancel() {
+		t.Fatal("cancel should succeed once")
+	}
+
+	var fired []*Timer
+	for w.cur <= 1<<23+7 {
+		now := w.cur
+		fired = w.tick(fired[:0])
+		for _, v := range fired {
+			fireAt[v] = now
+		}
+	}
+	for _, v := range timers {
+		if fireAt[v] != v.expire {
+			t.Fatalf("timer expire at %d fired at %d", v.expire, fireAt[v])
+		}
+	}
+	if _, ok := fireAt[cancelled]; ok {
+		t.Fatal("cancelled timer fired")
+	}
+}
+
+func Test_TimerRepeatReset(t *testing.T) {
+	app := NewApp()
+	defer app.Stop()
+	var cnt int32
+	every := app.Every(5, func() { atomic.AddInt32(&cnt, 1) })
+	once := app.AfterFunc(50, func() { atomic.AddInt32(&cnt, 1000) })
+	once.Reset(2000)
+	time.Sleep(200 * time.Millisecond)
+	if !every.Cancel() || !once.Cancel() {
+		t.Fatal("timers should be pending")
+	}
+	if c := atomic.LoadInt32(&cnt); c < 5 || c >= 1000 {
+		t.Fatalf("unexpected fire count %d", c)
+	}
+}
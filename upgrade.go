@@ -0,0 +1,230 @@
+package sugar
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	upgradeEnvListeners = "SUGAR_UPGRADE_LISTENERS" // network://addr of each inherited fd, starts from fd 3
+	upgradeEnvReady     = "SUGAR_UPGRADE_READY"     // fd of the pipe used to tell parent child is ready
+)
+
+// upgradeFiler is a listening socket, *net.TCPListener or *net.UDPConn
+type upgradeFiler interface {
+	File() (*os.File, error)
+}
+
+type upgradeListener struct {
+	network string
+	addr    string
+	socket  upgradeFiler
+}
+
+type inheritedFile struct {
+	network string
+	addr    string
+	file    *os.File
+}
+
+var upgradeListeners []*upgradeListener
+var inheritedFiles []*inheritedFile
+var inheritReady *os.File
+var inheritOnce sync.Once
+var upgradeLock sync.Mutex
+var upgrading int32
+
+// addUpgradeListener records a listening socket so it can be handed to the new process by Upgrade
+func addUpgradeListener(network, addr string, socket upgradeFiler) {
+	upgradeLock.Lock()
+	upgradeListeners = append(upgradeListeners, &upgradeListener{network: network, addr: addr, socket: socket})
+	upgradeLock.Unlock()
+}
+
+// removeUpgradeListener forgets a listening socket once it stops, so it is not handed to the new process
+func removeUpgradeListener(socket upgradeFiler) {
+	upgradeLock.Lock()
+	left := upgradeListeners[:0]
+	for _, v := range upgradeListeners {
+		if v.socket != socket {
+			left = append(left, v)
+		}
+	}
+	for i := len(left); i < len(upgradeListeners); i++ {
+		upgradeListeners[i] = nil
+	}
+	upgradeListeners = left
+	upgradeLock.Unlock()
+}
+
+func loadInherited() {
+	inheritOnce.Do(func() {
+		names := os.Getenv(upgradeEnvListeners)
+		ready := os.Getenv(upgradeEnvReady)
+		os.Unsetenv(upgradeEnvListeners)
+		os.Unsetenv(upgradeEnvReady)
+		if names != "" {
+			for i, v := range strings.Split(names, ",") {
+				info := strings.SplitN(v, "://", 2)
+				if len(info) != 2 {
+					continue
+				}
+				f := os.NewFile(uintptr(3+i), v)
+				inheritedFiles = append(inheritedFiles, &inheritedFile{network: info[0], addr: info[1], file: f})
+			}
+		}
+		if fd, err := strconv.Atoi(ready); err == nil {
+			inheritReady = os.NewFile(uintptr(fd), "upgrade ready")
+		}
+	})
+}
+
+// takeInherited returns files inherited from the parent process for network and addr
+func takeInherited(network, addr string) []*os.File {
+	loadInherited()
+	upgradeLock.Lock()
+	defer upgradeLock.Unlock()
+	var files []*os.File
+	left := inheritedFiles[:0]
+	for _, v := range inheritedFiles {
+		if v.network == network && v.addr == addr {
+			files = append(files, v.file)
+		} else {
+			left = append(left, v)
+		}
+	}
+	inheritedFiles = left
+	return files
+}
+
+func inheritTCP(addr string) []net.Listener {
+	var listeners []net.Listener
+	for _, f := range takeInherited("tcp", addr) {
+		listen, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			Errorf("inherit tcp listener %s failed, err:%v", addr, err)
+			continue
+		}
+		Infof("inherit tcp listener %s", addr)
+		listeners = append(listeners, listen)
+	}
+	return listeners
+}
+
+func inheritUDP(addr string) []*net.UDPConn {
+	var conns []*net.UDPConn
+	for _, f := range takeInherited("udp", addr) {
+		conn, err := net.FilePacketConn(f)
+		f.Close()
+		if err != nil {
+			Errorf("inherit udp conn %s failed, err:%v", addr, err)
+			continue
+		}
+		udp, ok := conn.(*net.UDPConn)
+		if !ok {
+			conn.Close()
+			continue
+		}
+		Infof("inherit udp conn %s", addr)
+		conns = append(conns, udp)
+	}
+	return conns
+}
+
+// upgradeReady tells the parent process that listeners are accepting, unused inherited files are closed
+func upgradeReady() {
+	loadInherited()
+	upgradeLock.Lock()
+	for _, v := range inheritedFiles {
+		Warnf("inherited listener %s://%s not used", v.network, v.addr)
+		v.file.Close()
+	}
+	inheritedFiles = nil
+	upgradeLock.Unlock()
+	if inheritReady != nil {
+		inheritReady.Write([]byte{1})
+		inheritReady.Close()
+		inheritReady = nil
+	}
+}
+
+// Upgrade starts a new process of the same binary and arguments which inherits all listening sockets,
+// it returns after the new process is ready, then the caller should Stop to drain its own connections.
+// udp datagrams of existing sessions may be received by the new process once it starts
+func Upgrade() error {
+	if !atomic.CompareAndSwapInt32(&upgrading, 0, 1) {
+		return ErrUpgradeRunning
+	}
+	defer atomic.StoreInt32(&upgrading, 0)
+
+	var files []*os.File
+	var names []string
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	upgradeLock.Lock()
+	for _, v := range upgradeListeners {
+		f, err := v.socket.File()
+		if err != nil {
+			continue // closed before it was removed
+		}
+		files = append(files, f)
+		names = append(names, v.network+"://"+v.addr)
+	}
+	upgradeLock.Unlock()
+
+	rd, wr, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer rd.Close()
+	path, err := os.Executable()
+	if err != nil {
+		wr.Close()
+		return err
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), upgradeEnvListeners+"="+strings.Join(names, ","), upgradeEnvReady+"="+strconv.Itoa(3+len(files)))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = append(append([]*os.File{}, files...), wr)
+	err = cmd.Start()
+	wr.Close()
+	if err != nil {
+		return err
+	}
+
+	readyCh := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := rd.Read(buf)
+		readyCh <- err
+	}()
+	select {
+	case err = <-readyCh:
+	case <-time.After(UpgradeTimeout):
+		err = ErrUpgradeTimeout
+	}
+	if err != nil {
+		Errorf("upgrade process:%d not ready, err:%v", cmd.Process.Pid, err)
+		cmd.Process.Kill()
+		cmd.Wait()
+		if err != ErrUpgradeTimeout {
+			err = ErrUpgradeFailed
+		}
+		return err
+	}
+	Infof("upgrade process:%d ready with %d listeners", cmd.Process.Pid, len(files))
+	cmd.Process.Release()
+	return nil
+}
+
+var UpgradeTimeout = 30 * time.Second // max time to wait for the new process to be ready
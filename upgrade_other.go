@@ -0,0 +1,9 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+package sugar
+
+import "os"
+
+// UpgradeSignal is not supported, listening sockets can't be inherited by ExtraFiles
+var UpgradeSignal os.Signal
@@ -0,0 +1,10 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package sugar
+
+import "os"
+
+// UpgradeSignal makes WaitForSystemExit invoke Upgrade and then stop, nil by default so upgrade is opt-in.
+// pick a signal nothing else sends, e.g. not SIGWINCH sent by terminals, StatSignal or LogReopenSignal
+var UpgradeSignal os.Signal
@@ -0,0 +1,88 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+package sugar
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+const upgradeTestEnv = "SUGAR_TEST_UPGRADE_ADDR"
+
+// Test_UpgradeChild is the process started by Test_Upgrade
+func Test_UpgradeChild(t *testing.T) {
+	addr := os.Getenv(upgradeTestEnv)
+	if addr == "" {
+		t.Skip("only run by Test_Upgrade")
+	}
+	listeners := inheritTCP(addr)
+	if len(listeners) != 1 {
+		t.Fatalf("inherited %d listeners", len(listeners))
+	}
+	upgradeReady()
+	c, err := listeners[0].Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write([]byte("child"))
+	c.Close()
+}
+
+func Test_Upgrade(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	addUpgradeListener("tcp", addr, l.(*net.TCPListener))
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^Test_UpgradeChild$"}
+	os.Setenv(upgradeTestEnv, addr)
+	defer func() {
+		os.Args = args
+		os.Unsetenv(upgradeTestEnv)
+		upgradeLock.Lock()
+		upgradeListeners = nil
+		upgradeLock.Unlock()
+	}()
+
+	if err := Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	// only the child accepts from now on
+	l.Close()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if data, err := io.ReadAll(c); err != nil || string(data) != "child" {
+		t.Fatalf("read %q err %v", data, err)
+	}
+}
+
+func Test_UpgradeListenerStop(t *testing.T) {
+	count := func() int {
+		upgradeLock.Lock()
+		defer upgradeLock.Unlock()
+		return len(upgradeListeners)
+	}
+	before := count()
+	app := NewApp()
+	if err := app.StartServer("all://127.0.0.1:0", MsgTypeMsg, &DefMsgHandler{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if count() != before+2 {
+		t.Fatalf("want %d listeners got %d", before+2, count())
+	}
+	// stopped listeners are not handed to a new process
+	app.Stop()
+	if count() != before {
+		t.Fatalf("want %d listeners got %d", before, count())
+	}
+}